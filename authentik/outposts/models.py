@@ -65,6 +65,9 @@ class OutpostConfig:
 
     container_image: str | None = field(default=None)
 
+    proxy_session_key_prefix: str | None = field(default=None)
+    proxy_session_redis_db: int | None = field(default=None)
+
     docker_network: str | None = field(default=None)
     docker_map_ports: bool = field(default=True)
     docker_labels: dict[str, str] | None = field(default=None)
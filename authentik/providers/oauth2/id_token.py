@@ -21,6 +21,7 @@ from authentik.providers.oauth2.constants import (
 from authentik.stages.password.stage import PLAN_CONTEXT_METHOD, PLAN_CONTEXT_METHOD_ARGS
 
 if TYPE_CHECKING:
+    from authentik.core.models import User
     from authentik.providers.oauth2.models import BaseGrantModel, OAuth2Provider
 
 
@@ -49,6 +50,23 @@ class SubModes(models.TextChoices):
     )
 
 
+def get_sub(provider: "OAuth2Provider", user: "User") -> str:
+    """Get the subject of the given user, according to the sub mode of the provider"""
+    if provider.sub_mode == SubModes.HASHED_USER_ID:
+        return user.uid
+    if provider.sub_mode == SubModes.USER_ID:
+        return str(user.pk)
+    if provider.sub_mode == SubModes.USER_UUID:
+        return str(user.uuid)
+    if provider.sub_mode == SubModes.USER_EMAIL:
+        return user.email
+    if provider.sub_mode == SubModes.USER_USERNAME:
+        return user.username
+    if provider.sub_mode == SubModes.USER_UPN:
+        return user.attributes.get("upn", user.uid)
+    raise ValueError(f"Provider {provider} has invalid sub_mode selected: {provider.sub_mode}")
+
+
 @dataclass(slots=True)
 class IDToken:
     """The primary extension that OpenID Connect makes to OAuth 2.0 to enable End-Users to be
@@ -88,6 +106,9 @@ class IDToken:
     at_hash: str | None = None
     # Session ID, https://openid.net/specs/openid-connect-frontchannel-1_0.html#ClaimsContents
     sid: str | None = None
+    # Actor, set when an admin impersonates the user,
+    # https://www.rfc-editor.org/rfc/rfc8693.html#section-4.1
+    act: dict[str, str] | None = None
 
     claims: dict[str, Any] = field(default_factory=dict)
 
@@ -104,22 +125,7 @@ class IDToken:
         id_token.aud = provider.client_id
         id_token.claims = {}
 
-        if provider.sub_mode == SubModes.HASHED_USER_ID:
-            id_token.sub = token.user.uid
-        elif provider.sub_mode == SubModes.USER_ID:
-            id_token.sub = str(token.user.pk)
-        elif provider.sub_mode == SubModes.USER_UUID:
-            id_token.sub = str(token.user.uuid)
-        elif provider.sub_mode == SubModes.USER_EMAIL:
-            id_token.sub = token.user.email
-        elif provider.sub_mode == SubModes.USER_USERNAME:
-            id_token.sub = token.user.username
-        elif provider.sub_mode == SubModes.USER_UPN:
-            id_token.sub = token.user.attributes.get("upn", token.user.uid)
-        else:
-            raise ValueError(
-                f"Provider {provider} has invalid sub_mode selected: {provider.sub_mode}"
-            )
+        id_token.sub = get_sub(provider, token.user)
 
         # Convert datetimes into timestamps.
         now = timezone.now()
@@ -127,6 +133,9 @@ class IDToken:
         id_token.auth_time = int(token.auth_time.timestamp())
         if token.session:
             id_token.sid = hash_session_key(token.session.session_key)
+            # The session belongs to the admin when they impersonate the user
+            if token.session.user_id != token.user_id:
+                id_token.act = {"sub": get_sub(provider, token.session.user)}
 
         # We use the timestamp of the user's last successful login (EventAction.LOGIN) for auth_time
         auth_event = get_login_event(token.session)
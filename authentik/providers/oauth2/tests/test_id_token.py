@@ -0,0 +1,81 @@
+"""Test ID Token"""
+
+from django.test import RequestFactory
+from django.utils import timezone
+from jwt import decode
+
+from authentik.core.models import Application, AuthenticatedSession
+from authentik.core.tests.utils import create_test_admin_user, create_test_flow, create_test_user
+from authentik.lib.generators import generate_id
+from authentik.providers.oauth2.id_token import IDToken, SubModes
+from authentik.providers.oauth2.models import (
+    AccessToken,
+    OAuth2Provider,
+    RedirectURI,
+    RedirectURIMatchingMode,
+)
+from authentik.providers.oauth2.tests.utils import OAuthTestCase
+
+
+class TestIDToken(OAuthTestCase):
+    """Test ID Token"""
+
+    def setUp(self) -> None:
+        super().setUp()
+        self.factory = RequestFactory()
+        self.provider = OAuth2Provider.objects.create(
+            name=generate_id(),
+            authorization_flow=create_test_flow(),
+            redirect_uris=[RedirectURI(RedirectURIMatchingMode.STRICT, "http://testserver")],
+            signing_key=self.keypair,
+            sub_mode=SubModes.USER_USERNAME,
+        )
+        # Needs to be assigned to an application for iss to be set
+        self.app = Application.objects.create(
+            name=generate_id(), slug=generate_id(), provider=self.provider
+        )
+        self.user = create_test_user()
+
+    def create_token(self, session_user) -> AccessToken:
+        """Create an access token of the test user issued in a session of `session_user`"""
+        return AccessToken.objects.create(
+            provider=self.provider,
+            user=self.user,
+            token=generate_id(),
+            auth_time=timezone.now(),
+            _scope="openid",
+            session=AuthenticatedSession.objects.create(
+                user=session_user,
+                session_key=generate_id(),
+            ),
+        )
+
+    def decode_access_token(self, id_token: IDToken) -> dict:
+        """Encode the ID Token as access token and decode it again"""
+        return decode(
+            id_token.to_access_token(self.provider),
+            self.keypair.public_key,
+            algorithms=[self.provider.jwt_key[1]],
+            audience=self.provider.client_id,
+        )
+
+    def test_act_impersonation(self):
+        """Test tokens issued while an admin impersonates the user are tagged with the admin"""
+        admin = create_test_admin_user()
+        token = self.create_token(admin)
+        id_token = IDToken.new(self.provider, token, self.factory.get("/"))
+        self.assertEqual(id_token.sub, self.user.username)
+        self.assertEqual(id_token.act, {"sub": admin.username})
+        jwt = self.decode_access_token(id_token)
+        self.assertEqual(jwt["sub"], self.user.username)
+        self.assertEqual(jwt["act"], {"sub": admin.username})
+
+    def test_act_no_impersonation(self):
+        """Test tokens issued in the user's own session have no actor"""
+        token = self.create_token(self.user)
+        id_token = IDToken.new(self.provider, token, self.factory.get("/"))
+        self.assertEqual(id_token.sub, self.user.username)
+        self.assertIsNone(id_token.act)
+        jwt = self.decode_access_token(id_token)
+        self.assertEqual(jwt["sub"], self.user.username)
+        self.assertNotIn("act", jwt)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -64,9 +65,17 @@ var rootCmd = &cobra.Command{
 
 		ex := common.Init()
 		defer common.Defer()
+		// Set once the outpost is started, so that its server is stopped on shutdown
+		var controller atomic.Pointer[ak.APIController]
 		go func() {
 			for {
 				<-ex
+				if ac := controller.Load(); ac != nil {
+					if err := ac.Server.Stop(); err != nil {
+						log.WithError(err).Warning("failed to stop server")
+					}
+					ac.Shutdown()
+				}
 				os.Exit(0)
 			}
 		}()
@@ -78,15 +87,15 @@ var rootCmd = &cobra.Command{
 		defer ac.Shutdown()
 
 		ac.Server = proxyv2.NewProxyServer(ac)
+		controller.Store(ac)
 
 		err = ac.Start()
 		if err != nil {
 			log.WithError(err).Panic("Failed to run server")
 		}
 
-		for {
-			<-ex
-		}
+		// Signals are handled above
+		select {}
 	},
 }
 
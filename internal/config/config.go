@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 
 	env "github.com/sethvargo/go-envconfig"
 	log "github.com/sirupsen/logrus"
@@ -20,6 +21,9 @@ import (
 
 var cfg *Config
 
+// redisPasswordPrecedence ensures the precedence of the Redis password file is only logged once
+var redisPasswordPrecedence sync.Once
+
 const defaultConfigPath = "./authentik/lib/default.yml"
 
 func getConfigPaths() []string {
@@ -69,6 +73,11 @@ func Get() *Config {
 }
 
 func (c *Config) Setup(paths ...string) {
+	c.load(paths...)
+	c.configureLogger()
+}
+
+func (c *Config) load(paths ...string) {
 	// initially try to load the default config which is compiled in
 	err := c.LoadConfig(lib.DefaultConfig())
 	// this should never fail
@@ -86,7 +95,46 @@ func (c *Config) Setup(paths ...string) {
 	if err != nil {
 		log.WithError(err).Info("failed to load env vars")
 	}
-	c.configureLogger()
+}
+
+// RedisCredentials loads the Redis username and password again from the config
+// files and environment, so that rotated credentials (for example from a file
+// referenced with file://) are picked up without a restart
+func RedisCredentials() (string, string) {
+	c := &Config{}
+	c.load(getConfigPaths()...)
+	return c.Redis.Username, c.Redis.GetPassword()
+}
+
+// GetSocketPath returns the path of the Unix socket of the Redis server, which is
+// SocketPath when set, or Host when it is an absolute path
+func (rc RedisConfig) GetSocketPath() string {
+	if rc.SocketPath != "" {
+		return rc.SocketPath
+	}
+	if strings.HasPrefix(rc.Host, "/") {
+		return rc.Host
+	}
+	return ""
+}
+
+// GetPassword returns the Redis password, which is read from PasswordFile when set,
+// without trailing whitespace and newlines
+func (rc RedisConfig) GetPassword() string {
+	if rc.PasswordFile == "" {
+		return rc.Password
+	}
+	if rc.Password != "" {
+		redisPasswordPrecedence.Do(func() {
+			log.Info("both a redis password and password file are set, using the password file")
+		})
+	}
+	data, err := os.ReadFile(rc.PasswordFile)
+	if err != nil {
+		log.WithError(err).Warning("failed to read redis password file")
+		return rc.Password
+	}
+	return strings.TrimRight(string(data), " \t\r\n")
 }
 
 func (c *Config) LoadConfig(raw []byte) error {
@@ -179,6 +227,63 @@ func (c *Config) parseScheme(rawVal string) string {
 	return rawVal
 }
 
+// ForApplication returns the proxy settings for the application with the given slug,
+// with the per-application overrides applied on top of the global settings. Overrides
+// loaded from YAML apply every setting they contain, including zero values such as false,
+// other overrides apply their non-zero settings.
+func (pc ProxyConfig) ForApplication(slug string) ProxyApplicationConfig {
+	merged := pc.ProxyApplicationConfig
+	override, ok := pc.Applications[slug]
+	if !ok {
+		return merged
+	}
+	mv := reflect.ValueOf(&merged).Elem()
+	ov := reflect.ValueOf(override)
+	for i := 0; i < ov.NumField(); i++ {
+		field := ov.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if override.set != nil {
+			if !override.set[yamlName(field)] {
+				continue
+			}
+		} else if ov.Field(i).IsZero() {
+			continue
+		}
+		mv.Field(i).Set(ov.Field(i))
+	}
+	return merged
+}
+
+// UnmarshalYAML records which settings are set in the YAML of every override, so that
+// ForApplication can tell a setting overridden with its zero value from an unset one
+func (o *ProxyApplicationOverrides) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	overrides := map[string]ProxyApplicationConfig{}
+	if err := unmarshal(&overrides); err != nil {
+		return err
+	}
+	keys := map[string]map[string]interface{}{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	for slug, ac := range overrides {
+		ac.set = make(map[string]bool, len(keys[slug]))
+		for key := range keys[slug] {
+			ac.set[key] = true
+		}
+		overrides[slug] = ac
+	}
+	*o = overrides
+	return nil
+}
+
+// yamlName returns the YAML key of the struct field f
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	return name
+}
+
 func (c *Config) configureLogger() {
 	switch strings.ToLower(c.LogLevel) {
 	case "trace":
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -48,3 +50,153 @@ func TestConfigEnv_File(t *testing.T) {
 	}
 	assert.Equal(t, "bar", Get().SecretKey)
 }
+
+func TestRedisCredentials(t *testing.T) {
+	file, err := os.CreateTemp("", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		assert.NoError(t, os.Remove(file.Name()))
+	}()
+	_, err = file.Write([]byte("foo"))
+	if err != nil {
+		panic(err)
+	}
+
+	assert.NoError(t, os.Setenv("AUTHENTIK_REDIS__PASSWORD", fmt.Sprintf("file://%s", file.Name())))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("AUTHENTIK_REDIS__PASSWORD"))
+	}()
+	_, password := RedisCredentials()
+	assert.Equal(t, "foo", password)
+
+	// Rotated credentials are picked up
+	assert.NoError(t, os.WriteFile(file.Name(), []byte("bar"), 0600))
+	_, password = RedisCredentials()
+	assert.Equal(t, "bar", password)
+}
+
+func TestRedisPasswordFile(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("foo\n"), 0600))
+	rc := RedisConfig{Password: "bar"}
+	assert.Equal(t, "bar", rc.GetPassword())
+
+	// The file takes precedence and is trimmed
+	rc.PasswordFile = passwordFile
+	assert.Equal(t, "foo", rc.GetPassword())
+
+	// Rotated passwords are picked up
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("baz \r\n"), 0600))
+	assert.Equal(t, "baz", rc.GetPassword())
+}
+
+func TestRedisGetSocketPath(t *testing.T) {
+	assert.Equal(t, "", RedisConfig{Host: "localhost"}.GetSocketPath())
+	assert.Equal(t, "/run/redis.sock", RedisConfig{Host: "/run/redis.sock"}.GetSocketPath())
+	assert.Equal(t, "/tmp/redis.sock", RedisConfig{Host: "/run/redis.sock", SocketPath: "/tmp/redis.sock"}.GetSocketPath())
+}
+
+func TestProxyConfigForApplication(t *testing.T) {
+	pc := ProxyConfig{
+		ProxyApplicationConfig: ProxyApplicationConfig{
+			CookieSameSite: "lax",
+		},
+		Applications: map[string]ProxyApplicationConfig{
+			"embedded": {
+				CookieSameSite: "none",
+			},
+			"empty": {},
+		},
+	}
+	assert.Equal(t, "none", pc.ForApplication("embedded").CookieSameSite)
+	assert.Equal(t, "lax", pc.ForApplication("empty").CookieSameSite)
+	assert.Equal(t, "lax", pc.ForApplication("unknown").CookieSameSite)
+}
+
+func TestProxyConfigYAML(t *testing.T) {
+	c := &Config{}
+	assert.NoError(t, c.LoadConfig([]byte(`
+proxy:
+  cookie_same_site: strict
+  applications:
+    embedded:
+      cookie_same_site: none
+`)))
+	assert.Equal(t, "strict", c.Proxy.CookieSameSite)
+	assert.Equal(t, "none", c.Proxy.ForApplication("embedded").CookieSameSite)
+
+	assert.NoError(t, os.Setenv("AUTHENTIK_PROXY__COOKIE_SAME_SITE", "lax"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("AUTHENTIK_PROXY__COOKIE_SAME_SITE"))
+	}()
+	assert.NoError(t, c.fromEnv())
+	assert.Equal(t, "lax", c.Proxy.CookieSameSite)
+	assert.Equal(t, "none", c.Proxy.ForApplication("embedded").CookieSameSite)
+}
+
+func TestProxyConfigYAMLZeroOverrides(t *testing.T) {
+	c := &Config{}
+	assert.NoError(t, c.LoadConfig([]byte(`
+proxy:
+  session_user_agent_binding: true
+  idle_timeout: 1h
+  applications:
+    disabled:
+      session_user_agent_binding: false
+      idle_timeout: 0s
+    other:
+      cookie_same_site: none
+    empty:
+`)))
+	// Overrides set to their zero value replace the global setting
+	assert.False(t, c.Proxy.ForApplication("disabled").SessionUserAgentBinding)
+	assert.Equal(t, time.Duration(0), c.Proxy.ForApplication("disabled").IdleTimeout)
+	// Settings which aren't overridden keep the global setting
+	for _, slug := range []string{"other", "empty", "unknown"} {
+		assert.True(t, c.Proxy.ForApplication(slug).SessionUserAgentBinding)
+		assert.Equal(t, time.Hour, c.Proxy.ForApplication(slug).IdleTimeout)
+	}
+	assert.Equal(t, "none", c.Proxy.ForApplication("other").CookieSameSite)
+}
+
+func TestRedisPoolConfig(t *testing.T) {
+	assert.NoError(t, os.Setenv("AUTHENTIK_REDIS__POOL_SIZE", "50"))
+	assert.NoError(t, os.Setenv("AUTHENTIK_REDIS__READ_TIMEOUT", "10s"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("AUTHENTIK_REDIS__POOL_SIZE"))
+		assert.NoError(t, os.Unsetenv("AUTHENTIK_REDIS__READ_TIMEOUT"))
+	}()
+	cfg = nil
+	assert.NoError(t, Get().LoadConfig([]byte("redis:\n  dial_timeout: 2s\n")))
+	if err := Get().fromEnv(); err != nil {
+		panic(err)
+	}
+	assert.Equal(t, 50, Get().Redis.PoolSize)
+	assert.Equal(t, 10*time.Second, Get().Redis.ReadTimeout)
+	assert.Equal(t, 2*time.Second, Get().Redis.DialTimeout)
+	// Unset values keep the go-redis defaults
+	assert.Equal(t, time.Duration(0), Get().Redis.WriteTimeout)
+	assert.Equal(t, 0, Get().Redis.MinIdleConns)
+}
+
+func TestSessionRedisDB(t *testing.T) {
+	cfg = nil
+	if err := Get().fromEnv(); err != nil {
+		panic(err)
+	}
+	// Unset values fall back to the global Redis database
+	assert.Nil(t, Get().Proxy.SessionRedisDB)
+
+	assert.NoError(t, os.Setenv("AUTHENTIK_PROXY__SESSION_REDIS_DB", "0"))
+	defer func() {
+		assert.NoError(t, os.Unsetenv("AUTHENTIK_PROXY__SESSION_REDIS_DB"))
+	}()
+	cfg = nil
+	if err := Get().fromEnv(); err != nil {
+		panic(err)
+	}
+	assert.NotNil(t, Get().Proxy.SessionRedisDB)
+	assert.Equal(t, 0, *Get().Proxy.SessionRedisDB)
+}
@@ -12,12 +12,14 @@ import (
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/getsentry/sentry-go"
 	sentryhttp "github.com/getsentry/sentry-go/http"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/prometheus/client_golang/prometheus"
@@ -25,9 +27,10 @@ import (
 	"goauthentik.io/api/v3"
 	"goauthentik.io/internal/config"
 	"goauthentik.io/internal/outpost/ak"
-	"goauthentik.io/internal/outpost/proxyv2/constants"
 	"goauthentik.io/internal/outpost/proxyv2/hs256"
 	"goauthentik.io/internal/outpost/proxyv2/metrics"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
 	"goauthentik.io/internal/outpost/proxyv2/templates"
 	"goauthentik.io/internal/utils/web"
 	"golang.org/x/oauth2"
@@ -41,8 +44,14 @@ type Application struct {
 	endpoint      OIDCEndpoint
 	oauthConfig   oauth2.Config
 	tokenVerifier *oidc.IDTokenVerifier
-	outpostName   string
-	sessionName   string
+	// logoutTokenVerifier verifies back-channel logout tokens, which don't have to expire
+	logoutTokenVerifier *oidc.IDTokenVerifier
+	outpostName         string
+	sessionName         string
+	// legacySessionNames are the session names used before the session name was derived
+	// from the application slug or prefixed, sessions with these names are still accepted
+	// and migrated
+	legacySessionNames []string
 
 	sessions             sessions.Store
 	proxyConfig          api.ProxyOutpostConfig
@@ -54,10 +63,36 @@ type Application struct {
 	ak  *ak.APIController
 	srv Server
 
+	// called with the result of every logout sweep
+	onLogout func(LogoutResult)
+	// preDelete is called with the claims of every session before Logout deletes it
+	preDelete func(Claims) error
+
+	// codecs of all applications, cached by getAllCodecs
+	codecs      []securecookie.Codec
+	codecsKey   string
+	codecsMutex sync.Mutex
+
+	// sessionsMutex guards sessions and redisFallback, as the fallback store is replaced
+	// once Redis is available
+	sessionsMutex sync.RWMutex
+	// options of the Redis store while a fallback store is used because Redis was
+	// unavailable when the store was created, nil otherwise
+	redisFallback *sessions.Options
+	// backend sessions are stored in, which differs from sessionBackend while a fallback
+	// store is used
+	effectiveBackend string
+	// called when the effective session backend changes
+	onBackendChange func(expected, effective string)
+	// logoutGuard serializes logout sweeps
+	logoutGuard *logoutGuard
+
 	errorTemplates  *template.Template
 	authHeaderCache *ttlcache.Cache[string, Claims]
 
 	isEmbedded bool
+	stop       chan struct{}
+	stopOnce   sync.Once
 }
 
 type Server interface {
@@ -68,10 +103,17 @@ type Server interface {
 
 func init() {
 	gob.Register(Claims{})
+	redisstore.RegisterJSONType(Claims{})
 }
 
 func NewApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, oldApp *Application) (*Application, error) {
-	muxLogger := log.WithField("logger", "authentik.outpost.proxyv2.application").WithField("name", p.Name)
+	return newApplication(p, c, server, oldApp, log.StandardLogger())
+}
+
+// newApplication creates an application logging to logger, which is set before the
+// goroutines of the application start logging
+func newApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, oldApp *Application, logger *log.Logger) (*Application, error) {
+	muxLogger := logger.WithField("logger", "authentik.outpost.proxyv2.application").WithField("name", p.Name)
 
 	externalHost, err := url.Parse(p.ExternalHost)
 	if err != nil {
@@ -108,6 +150,12 @@ func NewApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, old
 		SupportedSigningAlgs: []string{"RS256", "HS256"},
 	})
 
+	logoutTokenVerifier := oidc.NewVerifier(endpoint.Issuer, ks, &oidc.Config{
+		ClientID:             *p.ClientId,
+		SupportedSigningAlgs: []string{"RS256", "HS256"},
+		SkipExpiryCheck:      true,
+	})
+
 	oauth2Config := oauth2.Config{
 		ClientID:     *p.ClientId,
 		ClientSecret: *p.ClientSecret,
@@ -121,6 +169,27 @@ func NewApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, old
 	h := sha256.New()
 	bs := string(h.Sum([]byte(*p.ClientId)))
 	sessionName := fmt.Sprintf("authentik_proxy_%s", bs[:8])
+	legacySessionNames := []string{}
+	if slug := p.AssignedApplicationSlug; slug != "" {
+		// The client ID prefix isn't unique, so the session cookies of applications
+		// sharing a cookie domain could collide
+		legacySessionNames = append(legacySessionNames, sessionName)
+		sessionName = fmt.Sprintf("authentik_proxy_app_%s", slug)
+	}
+	prefix, err := cookieNamePrefix(config.Get().Proxy.ForApplication(p.AssignedApplicationSlug).CookiePrefix)
+	if err != nil {
+		muxLogger.WithError(err).Warning("invalid cookie prefix, not using a prefix")
+	}
+	if unknown := unknownClaims(config.Get().Proxy.ForApplication(p.AssignedApplicationSlug).PersistedClaims); len(unknown) > 0 {
+		muxLogger.WithField("claims", unknown).Warning("unknown persisted claims, ignoring")
+	}
+	if _, err := sessionIPBinding(config.Get().Proxy.ForApplication(p.AssignedApplicationSlug).SessionIPBinding); err != nil {
+		muxLogger.WithError(err).Warning("invalid session ip binding, not binding sessions")
+	}
+	if prefix != "" {
+		legacySessionNames = append([]string{sessionName}, legacySessionNames...)
+		sessionName = prefix + sessionName
+	}
 
 	// When HOST_BROWSER is set, use that as Host header for token requests to make the issuer match
 	// otherwise we use the internally configured authentik_host
@@ -135,9 +204,11 @@ func NewApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, old
 		log:                  muxLogger,
 		outpostName:          server.API().Outpost.Name,
 		sessionName:          sessionName,
+		legacySessionNames:   legacySessionNames,
 		endpoint:             endpoint,
 		oauthConfig:          oauth2Config,
 		tokenVerifier:        verifier,
+		logoutTokenVerifier:  logoutTokenVerifier,
 		proxyConfig:          p,
 		httpClient:           c,
 		publicHostHTTPClient: publicHTTPClient,
@@ -147,17 +218,32 @@ func NewApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, old
 		authHeaderCache:      ttlcache.New(ttlcache.WithDisableTouchOnHit[string, Claims]()),
 		srv:                  server,
 		isEmbedded:           isEmbedded,
+		stop:                 make(chan struct{}),
+		logoutGuard:          newLogoutGuard(),
+	}
+	if oldApp != nil {
+		a.logoutGuard = oldApp.logoutGuard
 	}
 	go a.authHeaderCache.Start()
-	if oldApp != nil && oldApp.sessions != nil {
-		a.sessions = oldApp.sessions
+	if oldApp != nil && oldApp.sessionStore() != nil {
+		a.sessions = oldApp.sessionStore()
+		a.effectiveBackend = oldApp.EffectiveSessionBackend()
+		if opts := oldApp.redisFallbackOptions(); opts != nil {
+			a.redisFallback = opts
+			go a.retryRedisStore(*opts)
+		}
 	} else {
 		sess, err := a.getStore(p, externalHost)
 		if err != nil {
 			return nil, err
 		}
 		a.sessions = sess
+		if config.Get().Proxy.SessionSelfTest {
+			a.runSelfTest()
+		}
 	}
+	go a.runSessionMetrics()
+	go a.runSessionCleanup()
 	mux.Use(web.NewLoggingHandler(muxLogger, func(l *log.Entry, r *http.Request) *log.Entry {
 		c := a.getClaimsFromSession(r)
 		if c == nil {
@@ -227,6 +313,8 @@ func NewApplication(p api.ProxyOutpostConfig, c *http.Client, server Server, old
 	})
 	mux.HandleFunc("/outpost.goauthentik.io/callback", a.handleAuthCallback)
 	mux.HandleFunc("/outpost.goauthentik.io/sign_out", a.handleSignOut)
+	mux.HandleFunc("/outpost.goauthentik.io/backchannel_logout", a.handleBackchannelLogout)
+	mux.HandleFunc("/outpost.goauthentik.io/frontchannel_logout", a.handleFrontchannelLogout).Methods(http.MethodGet)
 	switch *p.Mode {
 	case api.PROXYMODE_PROXY:
 		err = a.configureProxy()
@@ -287,32 +375,46 @@ func (a *Application) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	a.mux.ServeHTTP(rw, r)
 }
 
+// Stop stops the goroutines of the application, it can be called more than once
 func (a *Application) Stop() {
-	a.authHeaderCache.Stop()
+	a.stopOnce.Do(func() {
+		a.authHeaderCache.Stop()
+		close(a.stop)
+	})
+}
+
+// Close closes the connections of the session store. Unlike Stop, it must only be called
+// when the sessions aren't handed over to a new application, for example on shutdown.
+func (a *Application) Close() error {
+	switch store := a.sessionStore().(type) {
+	case *redisstore.RedisStore:
+		return store.Close()
+	case *postgresstore.PostgresStore:
+		releasePostgresPool(store.Pool())
+	}
+	return nil
 }
 
 func (a *Application) handleSignOut(rw http.ResponseWriter, r *http.Request) {
 	redirect := a.endpoint.EndSessionEndpoint
-	s, err := a.sessions.Get(r, a.SessionName())
+	s, err := a.getSession(r, a.SessionName())
 	if err != nil {
 		a.redirectToStart(rw, r)
 		return
 	}
-	c, exists := s.Values[constants.SessionClaims]
-	if c == nil && !exists {
+	cc, ok := sessionClaims(s.Values)
+	if !ok {
 		a.redirectToStart(rw, r)
 		return
 	}
-	cc := c.(Claims)
 	uv := url.Values{
 		"id_token_hint": []string{cc.RawToken},
 	}
 	redirect += "?" + uv.Encode()
-	err = a.Logout(r.Context(), func(c Claims) bool {
-		return c.Sub == cc.Sub
-	})
+	_, err = a.LogoutUser(r.Context(), cc.Sub)
 	if err != nil {
 		a.log.WithError(err).Warning("failed to logout of other sessions")
 	}
+	a.clearInfoCookie(rw, *s.Options)
 	http.Redirect(rw, r, redirect, http.StatusFound)
 }
@@ -1,10 +1,12 @@
 package application
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/gorilla/securecookie"
 	"goauthentik.io/internal/outpost/proxyv2/constants"
 )
 
@@ -12,7 +14,14 @@ import (
 // Returns an error if the session can't be loaded or the claims can't be parsed/type-cast
 func (a *Application) checkAuth(rw http.ResponseWriter, r *http.Request) (*Claims, error) {
 	c := a.getClaimsFromSession(r)
+	if c == nil && rw != nil {
+		c = a.migrateLegacySession(rw, r)
+	}
 	if c != nil {
+		if rw != nil {
+			a.rotateSessionID(rw, r, c)
+			a.refreshSession(rw, r, c)
+		}
 		return c, nil
 	}
 
@@ -48,18 +57,70 @@ func (a *Application) checkAuth(rw http.ResponseWriter, r *http.Request) (*Claim
 	return nil, fmt.Errorf("failed to get claims from session")
 }
 
-func (a *Application) getClaimsFromSession(r *http.Request) *Claims {
-	s, err := a.sessions.Get(r, a.SessionName())
+// migrateLegacySession moves a session stored with a legacy session name to the current
+// session name, so that sessions created before the session name was derived from the
+// application slug or prefixed stay valid. Returns the claims of the migrated session.
+func (a *Application) migrateLegacySession(rw http.ResponseWriter, r *http.Request) *Claims {
+	for _, name := range a.legacySessionNames {
+		if _, err := r.Cookie(name); err != nil {
+			continue
+		}
+		legacy, err := a.getSession(r, name)
+		if err != nil {
+			continue
+		}
+		c, ok := sessionClaims(legacy.Values)
+		if !ok {
+			continue
+		}
+		// A cookie with the current name which can't be decoded is replaced
+		s, _ := a.getSession(r, a.sessionName)
+		for k, v := range legacy.Values {
+			s.Values[k] = v
+		}
+		s.Options.MaxAge = a.sessionMaxAge(c)
+		if err := s.Save(r, rw); err != nil {
+			a.log.WithError(err).Warning("failed to migrate session")
+			return &c
+		}
+		legacy.Options.MaxAge = -1
+		if err := legacy.Save(r, rw); err != nil {
+			a.log.WithError(err).Warning("failed to delete legacy session")
+		}
+		a.log.WithField("name", name).Trace("migrated legacy session")
+		return &c
+	}
+	return nil
+}
+
+// ClaimsFromRequest returns the claims of the session of r, and false when r has no valid
+// session or the session has no claims
+func (a *Application) ClaimsFromRequest(r *http.Request) (Claims, bool) {
+	s, err := a.getSession(r, a.SessionName())
 	if err != nil {
 		// err == user has no session/session is not valid, reject
-		return nil
+		var scErr securecookie.Error
+		if errors.As(err, &scErr) && scErr.IsDecode() {
+			a.countDecodeError()
+			a.deleteExpiredCookieSession(r)
+		}
+		return Claims{}, false
 	}
-	claims, ok := s.Values[constants.SessionClaims]
-	if claims == nil || !ok {
-		// no claims saved, reject
-		return nil
+	if a.rejectTombstonedSession(s) {
+		return Claims{}, false
+	}
+	c, ok := sessionClaims(s.Values)
+	if ok && (a.deletePastDeadlineSession(r, s, c) ||
+		a.deleteExpiredSession(r, s, c) ||
+		a.deleteIPMismatchSession(r, s, c) ||
+		a.deleteUserAgentMismatchSession(r, s, c)) {
+		return Claims{}, false
 	}
-	c, ok := claims.(Claims)
+	return c, ok
+}
+
+func (a *Application) getClaimsFromSession(r *http.Request) *Claims {
+	c, ok := a.ClaimsFromRequest(r)
 	if !ok {
 		return nil
 	}
@@ -77,11 +138,14 @@ func (a *Application) getClaimsFromCache(r *http.Request) *Claims {
 }
 
 func (a *Application) saveAndCacheClaims(rw http.ResponseWriter, r *http.Request, claims Claims) (*Claims, error) {
-	s, _ := a.sessions.Get(r, a.SessionName())
+	s, _ := a.getSession(r, a.SessionName())
 
-	s.Values[constants.SessionClaims] = claims
+	s.Values[constants.SessionClaims] = a.persistedClaims(claims)
+	bindClientIP(r, s)
+	bindUserAgent(r, s)
 	err := s.Save(r, rw)
 	if err != nil {
+		a.logSessionTooLong(s, err)
 		return nil, err
 	}
 
@@ -1,5 +1,22 @@
 package application
 
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+)
+
+// requiredClaims are the claims which are always stored in sessions, as the outpost uses
+// them to identify, expire and log out sessions
+var requiredClaims = []string{"sub", "sid", "exp", "ak_proxy_session_created_at", "ak_proxy_session_expires_at", "ak_proxy_impersonator_sub"}
+
 type ProxyClaims struct {
 	UserAttributes  map[string]interface{} `json:"user_attributes"`
 	BackendOverride string                 `json:"backend_override"`
@@ -17,6 +34,146 @@ type Claims struct {
 	Entitlements      []string     `json:"entitlements"`
 	Sid               string       `json:"sid"`
 	Proxy             *ProxyClaims `json:"ak_proxy"`
+	// Unix timestamp at which the session was created, set by the outpost on login.
+	// Zero for sessions created before the timestamp was recorded
+	CreatedAt int64 `json:"ak_proxy_session_created_at"`
+	// Unix timestamp of the absolute deadline of the session, set by the outpost on login
+	// when a session max age is configured. Activity never extends the session beyond it.
+	// Zero for sessions without a deadline
+	ExpiresAt int64 `json:"ak_proxy_session_expires_at"`
+	// Subject of the admin impersonating the user, taken from the actor claim of the token
+	// on login. Empty for sessions which aren't impersonated
+	ImpersonatorSubject string `json:"ak_proxy_impersonator_sub"`
 
 	RawToken string
 }
+
+// CreatedBefore returns a Logout filter matching sessions created before t. Sessions
+// without a creation time are treated as created at the zero Unix time, so they match.
+func CreatedBefore(t time.Time) func(c Claims) bool {
+	return func(c Claims) bool {
+		return c.CreatedAt < t.Unix()
+	}
+}
+
+// Impersonated returns whether the session with claims c was created while an admin was
+// impersonating the user
+func (c Claims) Impersonated() bool {
+	return c.ImpersonatorSubject != ""
+}
+
+// sessionClaims returns the claims stored in the given session values, and false when the
+// values have no claims. Claims stored in another shape, for example by a different version
+// of the outpost, are converted by their JSON names.
+func sessionClaims(values map[interface{}]interface{}) (Claims, bool) {
+	switch c := values[constants.SessionClaims].(type) {
+	case nil:
+	case Claims:
+		return c, true
+	case *Claims:
+		if c != nil {
+			return *c, true
+		}
+	default:
+		return convertClaims(c)
+	}
+	return Claims{}, false
+}
+
+// convertClaims converts claims of another type, such as a map of claims of a JSON session
+// whose type isn't known, into Claims. Values without a subject aren't claims.
+func convertClaims(v interface{}) (Claims, bool) {
+	c := Claims{}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil || c.Sub == "" {
+		log.WithError(err).WithField("type", fmt.Sprintf("%T", v)).Debug("failed to convert session claims")
+		return Claims{}, false
+	}
+	return c, true
+}
+
+// InGroup returns a Logout filter matching sessions of users who were a member of the
+// given group when they logged in, for example to log out the users of a group which no
+// longer grants access to the application. Sessions which don't persist the groups
+// claim never match
+func InGroup(group string) func(c Claims) bool {
+	return func(c Claims) bool {
+		return slices.Contains(c.Groups, group)
+	}
+}
+
+// claimName returns the name of a Claims field as configured in PersistedClaims
+func claimName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	if f.Name == "RawToken" {
+		return "raw_token"
+	}
+	return f.Name
+}
+
+// largestClaim returns the JSON name and encoded length of the largest claim of c
+func largestClaim(c Claims) (string, int) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", 0
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return "", 0
+	}
+	name, length := "", 0
+	for n, v := range raw {
+		if len(v) > length || (len(v) == length && n < name) {
+			name, length = n, len(v)
+		}
+	}
+	return name, length
+}
+
+// unknownClaims returns the names in claims which aren't names of claims
+func unknownClaims(claims []string) []string {
+	known := []string{}
+	t := reflect.TypeFor[Claims]()
+	for i := range t.NumField() {
+		known = append(known, claimName(t.Field(i)))
+	}
+	unknown := []string{}
+	for _, name := range claims {
+		if !slices.Contains(known, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// filterClaims returns a copy of c with only the claims in persisted and the required
+// claims set, or c itself when persisted is empty
+func filterClaims(c Claims, persisted []string) Claims {
+	if len(persisted) == 0 {
+		return c
+	}
+	filtered := Claims{}
+	cv := reflect.ValueOf(c)
+	fv := reflect.ValueOf(&filtered).Elem()
+	for i := range cv.NumField() {
+		name := claimName(cv.Type().Field(i))
+		if slices.Contains(persisted, name) || slices.Contains(requiredClaims, name) {
+			fv.Field(i).Set(cv.Field(i))
+		}
+	}
+	// The proxy claims are expected to be set
+	if filtered.Proxy == nil {
+		filtered.Proxy = &ProxyClaims{}
+	}
+	return filtered
+}
+
+// persistedClaims returns the claims of c which are stored in sessions of the application
+func (a *Application) persistedClaims(c Claims) Claims {
+	return filterClaims(c, config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).PersistedClaims)
+}
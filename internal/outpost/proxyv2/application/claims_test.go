@@ -0,0 +1,176 @@
+package application
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+func TestClaims_JSONSerializer(t *testing.T) {
+	claims := &Claims{
+		Sub:    "foo",
+		Groups: []string{"admins", "users"},
+		Sid:    "bar",
+		Proxy: &ProxyClaims{
+			UserAttributes: map[string]interface{}{
+				"nested": map[string]interface{}{
+					"key": "value",
+					"list": []interface{}{
+						"a", "b",
+					},
+				},
+			},
+			BackendOverride: "http://backend",
+		},
+		RawToken: "token",
+	}
+	s := sessions.NewSession(nil, "authentik_proxy")
+	s.Values[constants.SessionClaims] = &claims
+	b, err := redisstore.JSONSerializer{}.Serialize(s)
+	assert.NoError(t, err)
+
+	d := sessions.NewSession(nil, "authentik_proxy")
+	assert.NoError(t, redisstore.JSONSerializer{}.Deserialize(b, d))
+	assert.Equal(t, *claims, d.Values[constants.SessionClaims])
+}
+
+func TestClaims_JSONSerializer_Gob(t *testing.T) {
+	s := sessions.NewSession(nil, "authentik_proxy")
+	s.Values[constants.SessionClaims] = Claims{
+		Sub: "foo",
+		Proxy: &ProxyClaims{
+			UserAttributes: map[string]interface{}{
+				"key": "value",
+			},
+		},
+	}
+	b, err := redisstore.GobSerializer{}.Serialize(s)
+	assert.NoError(t, err)
+
+	d := sessions.NewSession(nil, "authentik_proxy")
+	assert.NoError(t, redisstore.JSONSerializer{}.Deserialize(b, d))
+	assert.Equal(t, s.Values[constants.SessionClaims], d.Values[constants.SessionClaims])
+}
+
+func TestClaims_CreatedAtMissing(t *testing.T) {
+	// Sessions stored before the creation time was recorded decode with a zero value
+	b := []byte(`{"version":1,"values":{"claims":{"type":"application.Claims","value":{"sub":"foo"}}}}`)
+	d := sessions.NewSession(nil, "authentik_proxy")
+	assert.NoError(t, redisstore.JSONSerializer{}.Deserialize(b, d))
+	claims := d.Values[constants.SessionClaims].(Claims)
+	assert.Equal(t, "foo", claims.Sub)
+	assert.Zero(t, claims.CreatedAt)
+	assert.True(t, CreatedBefore(time.Now())(claims))
+}
+
+func TestCreatedBefore(t *testing.T) {
+	now := time.Now()
+	filter := CreatedBefore(now)
+	assert.True(t, filter(Claims{CreatedAt: now.Add(-time.Hour).Unix()}))
+	assert.False(t, filter(Claims{CreatedAt: now.Unix()}))
+}
+
+func TestFilterClaims(t *testing.T) {
+	c := Claims{
+		Sub:       "foo",
+		Sid:       "bar",
+		Exp:       1,
+		CreatedAt: 2,
+		Email:     "foo@goauthentik.io",
+		Groups:    []string{"baz"},
+		Proxy:     &ProxyClaims{IsSuperuser: true},
+		RawToken:  "token",
+	}
+	assert.Equal(t, c, filterClaims(c, nil))
+	assert.Equal(t, Claims{
+		Sub:       "foo",
+		Sid:       "bar",
+		Exp:       1,
+		CreatedAt: 2,
+		Groups:    []string{"baz"},
+		Proxy:     &ProxyClaims{},
+		RawToken:  "token",
+	}, filterClaims(c, []string{"groups", "raw_token"}))
+	assert.Equal(t, []string{"foo"}, unknownClaims([]string{"email", "ak_proxy", "raw_token", "foo"}))
+}
+
+func TestClaimsFromRequest(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	_, ok := a.ClaimsFromRequest(req)
+	assert.False(t, ok)
+
+	// Claims are stored by value and by pointer
+	for _, claims := range []interface{}{Claims{Sub: "foo"}, &Claims{Sub: "foo"}, "foo"} {
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = claims
+		rr := httptest.NewRecorder()
+		assert.NoError(t, a.sessions.Save(req, rr, s))
+
+		r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		r.AddCookie(rr.Result().Cookies()[0])
+		c, ok := a.ClaimsFromRequest(r)
+		if _, invalid := claims.(string); invalid {
+			assert.False(t, ok)
+			continue
+		}
+		assert.True(t, ok)
+		assert.Equal(t, "foo", c.Sub)
+	}
+}
+
+// claimsV0 has the layout of claims stored by an earlier version of the outpost, with a
+// field which has since been removed and without most current fields
+type claimsV0 struct {
+	Sub     string   `json:"sub"`
+	Groups  []string `json:"groups"`
+	Removed string   `json:"removed"`
+}
+
+func TestSessionClaims_SchemaDrift(t *testing.T) {
+	// The old layout is registered under a name of the same length as the current one,
+	// so the type name in the blob can be swapped for the current type
+	current := "goauthentik.io/internal/outpost/proxyv2/application.Claims"
+	old := "goauthentik.io/internal/outpost/proxyv2/application.Claim0"
+	gob.RegisterName(old, claimsV0{})
+	s := sessions.NewSession(nil, "authentik_proxy")
+	s.Values[constants.SessionClaims] = claimsV0{Sub: "foo", Groups: []string{"bar"}, Removed: "baz"}
+	b, err := redisstore.GobSerializer{}.Serialize(s)
+	assert.NoError(t, err)
+
+	d := sessions.NewSession(nil, "authentik_proxy")
+	assert.NoError(t, redisstore.GobSerializer{}.Deserialize(bytes.Replace(b, []byte(old), []byte(current), 1), d))
+	c, ok := sessionClaims(d.Values)
+	assert.True(t, ok)
+	assert.Equal(t, Claims{Sub: "foo", Groups: []string{"bar"}}, c)
+
+	// Claims of a type which is no longer known are re-decoded by their JSON names
+	j := []byte(`{"version":1,"values":{"claims":{"type":"application.ClaimsV0","value":{"sub":"foo","groups":["bar"],"removed":"baz"}}}}`)
+	d = sessions.NewSession(nil, "authentik_proxy")
+	assert.NoError(t, redisstore.JSONSerializer{}.Deserialize(j, d))
+	c, ok = sessionClaims(d.Values)
+	assert.True(t, ok)
+	assert.Equal(t, Claims{Sub: "foo", Groups: []string{"bar"}}, c)
+
+	// Values which can't be converted are skipped
+	for _, v := range []interface{}{
+		claimsV0{Groups: []string{"bar"}},
+		map[string]interface{}{"sub": "foo", "exp": "never"},
+	} {
+		_, ok = sessionClaims(map[interface{}]interface{}{constants.SessionClaims: v})
+		assert.False(t, ok)
+	}
+}
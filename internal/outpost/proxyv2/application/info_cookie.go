@@ -0,0 +1,54 @@
+package application
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+)
+
+// infoCookieClaims are the claims exposed to JavaScript by the informational cookie
+type infoCookieClaims struct {
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+}
+
+// infoCookieName returns the name of the informational cookie, empty when it's disabled
+func (a *Application) infoCookieName() string {
+	return config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).InfoCookieName
+}
+
+// setInfoCookie sets the informational cookie, which contains the display claims of the
+// user as base64 encoded JSON, so that frontends can show who is logged in. Unlike the
+// session cookie, it is readable from JavaScript and grants no access.
+func (a *Application) setInfoCookie(rw http.ResponseWriter, opts sessions.Options, c *Claims) {
+	name := a.infoCookieName()
+	if name == "" || name == a.SessionName() {
+		return
+	}
+	value, err := json.Marshal(infoCookieClaims{
+		PreferredUsername: c.PreferredUsername,
+		Name:              c.Name,
+		Email:             c.Email,
+	})
+	if err != nil {
+		a.log.WithError(err).Warning("failed to encode info cookie")
+		return
+	}
+	opts.HttpOnly = false
+	http.SetCookie(rw, sessions.NewCookie(name, base64.RawURLEncoding.EncodeToString(value), &opts))
+}
+
+// clearInfoCookie removes the informational cookie when the session is logged out
+func (a *Application) clearInfoCookie(rw http.ResponseWriter, opts sessions.Options) {
+	name := a.infoCookieName()
+	if name == "" || name == a.SessionName() {
+		return
+	}
+	opts.HttpOnly = false
+	opts.MaxAge = -1
+	http.SetCookie(rw, sessions.NewCookie(name, "", &opts))
+}
@@ -0,0 +1,48 @@
+package application
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+)
+
+func TestInfoCookie(t *testing.T) {
+	a := newTestApplication(t)
+	opts := sessions.Options{Path: "/", MaxAge: 3600, HttpOnly: true, Secure: true}
+	claims := &Claims{PreferredUsername: "foo", Email: "foo@goauthentik.io", RawToken: "token"}
+
+	// The cookie is opt-in
+	rr := httptest.NewRecorder()
+	a.setInfoCookie(rr, opts, claims)
+	assert.Empty(t, rr.Result().Cookies())
+
+	config.Get().Proxy.InfoCookieName = "authentik_proxy_info"
+	defer func() {
+		config.Get().Proxy.InfoCookieName = ""
+	}()
+	rr = httptest.NewRecorder()
+	a.setInfoCookie(rr, opts, claims)
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "authentik_proxy_info", cookies[0].Name)
+	assert.False(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Secure)
+	raw, err := base64.RawURLEncoding.DecodeString(cookies[0].Value)
+	assert.NoError(t, err)
+	info := infoCookieClaims{}
+	assert.NoError(t, json.Unmarshal(raw, &info))
+	assert.Equal(t, infoCookieClaims{PreferredUsername: "foo", Email: "foo@goauthentik.io"}, info)
+	// The session options are unchanged
+	assert.True(t, opts.HttpOnly)
+
+	rr = httptest.NewRecorder()
+	a.clearInfoCookie(rr, opts)
+	cookies = rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
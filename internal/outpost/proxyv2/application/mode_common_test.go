@@ -18,7 +18,7 @@ func urlMustParse(u string) *url.URL {
 }
 
 func TestIsAllowlisted_Proxy_Single(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_PROXY.Ptr()
 
 	assert.Equal(t, false, a.IsAllowlisted(urlMustParse("")))
@@ -29,7 +29,7 @@ func TestIsAllowlisted_Proxy_Single(t *testing.T) {
 }
 
 func TestIsAllowlisted_Proxy_Domain(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 
 	assert.Equal(t, false, a.IsAllowlisted(urlMustParse("")))
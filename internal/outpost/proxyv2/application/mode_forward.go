@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-
-	"goauthentik.io/internal/outpost/proxyv2/constants"
 )
 
 const (
@@ -135,9 +133,8 @@ func (a *Application) forwardHandleNginx(rw http.ResponseWriter, r *http.Request
 		return
 	}
 
-	s, _ := a.sessions.Get(r, a.SessionName())
-	if _, redirectSet := s.Values[constants.SessionRedirect]; !redirectSet {
-		s.Values[constants.SessionRedirect] = fwd.String()
+	s, _ := a.getSession(r, a.SessionName())
+	if a.setSessionRedirect(s, fwd.String()) {
 		err = s.Save(r, rw)
 		if err != nil {
 			a.log.WithError(err).Warning("failed to save session before redirect")
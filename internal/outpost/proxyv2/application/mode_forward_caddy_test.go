@@ -14,7 +14,7 @@ import (
 )
 
 func TestForwardHandleCaddy_Single_Blank(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/caddy", nil)
 
 	rr := httptest.NewRecorder()
@@ -24,7 +24,7 @@ func TestForwardHandleCaddy_Single_Blank(t *testing.T) {
 }
 
 func TestForwardHandleCaddy_Single_Skip(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/caddy", nil)
 	req.Header.Set("X-Forwarded-Proto", "http")
 	req.Header.Set("X-Forwarded-Host", "test.goauthentik.io")
@@ -37,7 +37,7 @@ func TestForwardHandleCaddy_Single_Skip(t *testing.T) {
 }
 
 func TestForwardHandleCaddy_Single_Headers(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/caddy", nil)
 	req.Header.Set("X-Forwarded-Proto", "http")
 	req.Header.Set("X-Forwarded-Host", "test.goauthentik.io")
@@ -58,7 +58,7 @@ func TestForwardHandleCaddy_Single_Headers(t *testing.T) {
 }
 
 func TestForwardHandleCaddy_Single_Claims(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/caddy", nil)
 	req.Header.Set("X-Forwarded-Proto", "http")
 	req.Header.Set("X-Forwarded-Host", "test.goauthentik.io")
@@ -107,7 +107,7 @@ func TestForwardHandleCaddy_Single_Claims(t *testing.T) {
 }
 
 func TestForwardHandleCaddy_Domain_Blank(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 	a.proxyConfig.CookieDomain = api.PtrString("foo")
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/caddy", nil)
@@ -119,7 +119,7 @@ func TestForwardHandleCaddy_Domain_Blank(t *testing.T) {
 }
 
 func TestForwardHandleCaddy_Domain_Header(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 	a.proxyConfig.CookieDomain = api.PtrString("foo")
 	a.proxyConfig.ExternalHost = "http://auth.test.goauthentik.io"
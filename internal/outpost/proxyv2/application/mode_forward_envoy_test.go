@@ -14,7 +14,7 @@ import (
 )
 
 func TestForwardHandleEnvoy_Single_Skip(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "http://test.goauthentik.io/skip", nil)
 
 	rr := httptest.NewRecorder()
@@ -24,7 +24,7 @@ func TestForwardHandleEnvoy_Single_Skip(t *testing.T) {
 }
 
 func TestForwardHandleEnvoy_Single_Headers(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "http:///app", nil)
 	req.Host = "ext.t.goauthentik.io"
 
@@ -43,7 +43,7 @@ func TestForwardHandleEnvoy_Single_Headers(t *testing.T) {
 }
 
 func TestForwardHandleEnvoy_Single_Claims(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "http://test.goauthentik.io/app", nil)
 
 	rr := httptest.NewRecorder()
@@ -89,7 +89,7 @@ func TestForwardHandleEnvoy_Single_Claims(t *testing.T) {
 }
 
 func TestForwardHandleEnvoy_Domain_Header(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 	a.proxyConfig.CookieDomain = api.PtrString("foo")
 	a.proxyConfig.ExternalHost = "http://auth.test.goauthentik.io"
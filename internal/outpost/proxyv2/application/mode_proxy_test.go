@@ -12,7 +12,7 @@ import (
 )
 
 func TestProxy_ModifyRequest(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "http://frontend/foo", nil)
 	u, err := url.Parse("http://backend:8012")
 	if err != nil {
@@ -27,7 +27,7 @@ func TestProxy_ModifyRequest(t *testing.T) {
 }
 
 func TestProxy_Redirect(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	_ = a.configureProxy()
 	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
 	rr := httptest.NewRecorder()
@@ -44,7 +44,7 @@ func TestProxy_Redirect(t *testing.T) {
 }
 
 func TestProxy_Redirect_Subdirectory(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.ExternalHost = a.proxyConfig.ExternalHost + "/subdir"
 	_ = a.configureProxy()
 	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
@@ -62,7 +62,7 @@ func TestProxy_Redirect_Subdirectory(t *testing.T) {
 }
 
 func TestProxy_ModifyRequest_Claims(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "http://frontend/foo", nil)
 	u, err := url.Parse("http://backend:8012")
 	if err != nil {
@@ -92,7 +92,7 @@ func TestProxy_ModifyRequest_Claims(t *testing.T) {
 }
 
 func TestProxy_ModifyRequest_Claims_Invalid(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	req, _ := http.NewRequest("GET", "http://frontend/foo", nil)
 	u, err := url.Parse("http://backend:8012")
 	if err != nil {
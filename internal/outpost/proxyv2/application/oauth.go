@@ -9,6 +9,9 @@ import (
 	"goauthentik.io/internal/outpost/proxyv2/constants"
 )
 
+// maxRedirectLength is the maximum length of URLs to redirect to after login
+const maxRedirectLength = 2048
+
 const (
 	redirectParam     = "rd"
 	CallbackSignature = "X-authentik-auth-callback"
@@ -21,7 +24,10 @@ func (a *Application) handleAuthStart(rw http.ResponseWriter, r *http.Request, f
 		a.log.WithError(err).Warning("failed to create state")
 		return
 	}
-	s, _ := a.sessions.Get(r, a.SessionName())
+	s, _ := a.getSession(r, a.SessionName())
+	// The callback saves the session again with the options of the store
+	opts := a.authCookieOptions(*s.Options)
+	s.Options = &opts
 	err = s.Save(r, rw)
 	if err != nil {
 		a.log.WithError(err).Warning("failed to save session")
@@ -30,7 +36,7 @@ func (a *Application) handleAuthStart(rw http.ResponseWriter, r *http.Request, f
 }
 
 func (a *Application) redirectToStart(rw http.ResponseWriter, r *http.Request) {
-	s, err := a.sessions.Get(r, a.SessionName())
+	s, err := a.getSession(r, a.SessionName())
 	if err != nil {
 		a.log.WithError(err).Warning("failed to decode session")
 	}
@@ -49,7 +55,7 @@ func (a *Application) redirectToStart(rw http.ResponseWriter, r *http.Request) {
 	redirectUrl := urlJoin(a.proxyConfig.ExternalHost, r.URL.Path)
 
 	if a.Mode() == api.PROXYMODE_FORWARD_DOMAIN {
-		dom := strings.TrimPrefix(*a.proxyConfig.CookieDomain, ".")
+		dom := strings.TrimPrefix(a.proxyConfig.GetCookieDomain(), ".")
 		// In forward_domain we only check that the current URL's host
 		// ends with the cookie domain (remove the leading period if set)
 		if !strings.HasSuffix(r.URL.Hostname(), dom) {
@@ -57,8 +63,7 @@ func (a *Application) redirectToStart(rw http.ResponseWriter, r *http.Request) {
 			redirectUrl = a.proxyConfig.ExternalHost
 		}
 	}
-	if _, redirectSet := s.Values[constants.SessionRedirect]; !redirectSet {
-		s.Values[constants.SessionRedirect] = redirectUrl
+	if a.setSessionRedirect(s, redirectUrl) {
 		err = s.Save(r, rw)
 		if err != nil {
 			a.log.WithError(err).Warning("failed to save session before redirect")
@@ -0,0 +1,111 @@
+package application
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// backchannelLogoutEvent is the event a logout token has to contain
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken
+const backchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenType is the typ header of explicitly typed logout tokens
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#Validation
+const logoutTokenType = "logout+jwt"
+
+// logoutTokenMaxAge is how long after they were issued logout tokens are accepted, so that
+// logout tokens can't be replayed later. Logout tokens don't expire, as the verifier
+// skips the expiry check.
+const logoutTokenMaxAge = 5 * time.Minute
+
+type logoutTokenClaims struct {
+	Sub    string                 `json:"sub"`
+	Sid    string                 `json:"sid"`
+	Nonce  *string                `json:"nonce"`
+	Events map[string]interface{} `json:"events"`
+}
+
+// handleBackchannelLogout handles OpenID Connect back-channel logout requests, deleting
+// all sessions matching the session ID (or subject) of the logout token
+func (a *Application) handleBackchannelLogout(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	claims, err := a.verifyLogoutToken(r)
+	if err != nil {
+		a.log.WithError(err).Warning("invalid logout token")
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var deleted int
+	if claims.Sid != "" {
+		deleted, err = a.LogoutSID(r.Context(), claims.Sid)
+	} else {
+		deleted, err = a.LogoutUser(r.Context(), claims.Sub)
+	}
+	if err != nil {
+		a.log.WithError(err).Warning("failed to logout sessions")
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.log.WithField("sid", claims.Sid).WithField("deleted", deleted).Debug("handled back-channel logout")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (a *Application) verifyLogoutToken(r *http.Request) (*logoutTokenClaims, error) {
+	raw := r.PostFormValue("logout_token")
+	if raw == "" {
+		return nil, fmt.Errorf("no logout token")
+	}
+	token, err := a.logoutTokenVerifier.Verify(r.Context(), raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLogoutTokenType(raw); err != nil {
+		return nil, err
+	}
+	if token.IssuedAt.IsZero() {
+		return nil, fmt.Errorf("logout token doesn't contain iat")
+	}
+	if age := time.Since(token.IssuedAt); age > logoutTokenMaxAge || age < -logoutTokenMaxAge {
+		return nil, fmt.Errorf("logout token was issued at %s, outside of %s", token.IssuedAt, logoutTokenMaxAge)
+	}
+	claims := &logoutTokenClaims{}
+	if err := token.Claims(claims); err != nil {
+		return nil, err
+	}
+	if _, ok := claims.Events[backchannelLogoutEvent]; !ok {
+		return nil, fmt.Errorf("logout token doesn't contain the back-channel logout event")
+	}
+	if claims.Nonce != nil {
+		return nil, fmt.Errorf("logout token must not contain a nonce")
+	}
+	if claims.Sid == "" && claims.Sub == "" {
+		return nil, fmt.Errorf("logout token contains neither sid nor sub")
+	}
+	return claims, nil
+}
+
+// checkLogoutTokenType checks the typ header of the logout token raw, which has been
+// verified already. Tokens without a typ header are accepted, as typing them is optional.
+func checkLogoutTokenType(raw string) error {
+	token, _, err := jwt.NewParser().ParseUnverified(raw, jwt.MapClaims{})
+	if err != nil {
+		return err
+	}
+	typ, ok := token.Header["typ"].(string)
+	if !ok {
+		return nil
+	}
+	typ = strings.TrimPrefix(strings.ToLower(typ), "application/")
+	if typ != logoutTokenType {
+		return fmt.Errorf("logout token has the type %s instead of %s", typ, logoutTokenType)
+	}
+	return nil
+}
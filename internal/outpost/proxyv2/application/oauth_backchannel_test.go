@@ -0,0 +1,129 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/hs256"
+)
+
+func newBackchannelTestApplication(t *testing.T) *Application {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	t.Cleanup(func() {
+		config.Get().Proxy.SessionBackend = ""
+	})
+	a := newTestApplication(t)
+	a.logoutTokenVerifier = oidc.NewVerifier("http://fake-auth.t.goauthentik.io", hs256.NewKeySet("secret"), &oidc.Config{
+		ClientID:             *a.proxyConfig.ClientId,
+		SupportedSigningAlgs: []string{"HS256"},
+		SkipExpiryCheck:      true,
+	})
+	return a
+}
+
+func logoutTokenRequest(t *testing.T, a *Application, claims jwt.MapClaims) *http.Request {
+	return typedLogoutTokenRequest(t, a, claims, logoutTokenType)
+}
+
+// typedLogoutTokenRequest returns a back-channel logout request with a logout token with the
+// given typ header, which is omitted when typ is empty
+func typedLogoutTokenRequest(t *testing.T, a *Application, claims jwt.MapClaims, typ string) *http.Request {
+	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if typ != "" {
+		jwtToken.Header["typ"] = typ
+	} else {
+		delete(jwtToken.Header, "typ")
+	}
+	token, err := jwtToken.SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	body := url.Values{"logout_token": []string{token}}.Encode()
+	req := httptest.NewRequest("POST", "https://ext.t.goauthentik.io/outpost.goauthentik.io/backchannel_logout", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func logoutTokenClaimsFor(a *Application, sid string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss": "http://fake-auth.t.goauthentik.io",
+		"aud": *a.proxyConfig.ClientId,
+		"iat": time.Now().Unix(),
+		"sid": sid,
+		"events": map[string]interface{}{
+			backchannelLogoutEvent: map[string]interface{}{},
+		},
+	}
+}
+
+func TestBackchannelLogout(t *testing.T) {
+	a := newBackchannelTestApplication(t)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	for _, sid := range []string{"foo", "bar"} {
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = Claims{Sub: "user", Sid: sid}
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	}
+
+	rr := httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, logoutTokenRequest(t, a, logoutTokenClaimsFor(a, "foo")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	claims, err := a.Sessions(req.Context())
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Equal(t, "bar", claims[0].Sid)
+
+	// Tokens which match no session are a no-op
+	rr = httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, logoutTokenRequest(t, a, logoutTokenClaimsFor(a, "baz")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestBackchannelLogout_Invalid(t *testing.T) {
+	a := newBackchannelTestApplication(t)
+
+	noEvent := logoutTokenClaimsFor(a, "foo")
+	delete(noEvent, "events")
+	withNonce := logoutTokenClaimsFor(a, "foo")
+	withNonce["nonce"] = "foo"
+	noIat := logoutTokenClaimsFor(a, "foo")
+	delete(noIat, "iat")
+	// Logout tokens can't be replayed long after they were issued
+	old := logoutTokenClaimsFor(a, "foo")
+	old["iat"] = time.Now().Add(-logoutTokenMaxAge - time.Minute).Unix()
+	future := logoutTokenClaimsFor(a, "foo")
+	future["iat"] = time.Now().Add(logoutTokenMaxAge + time.Minute).Unix()
+	for _, claims := range []jwt.MapClaims{noEvent, withNonce, noIat, old, future} {
+		rr := httptest.NewRecorder()
+		a.mux.ServeHTTP(rr, logoutTokenRequest(t, a, claims))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	}
+
+	// Other tokens signed for the client, such as ID tokens, aren't accepted
+	rr := httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, typedLogoutTokenRequest(t, a, logoutTokenClaimsFor(a, "foo"), "JWT"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, httptest.NewRequest("POST", "https://ext.t.goauthentik.io/outpost.goauthentik.io/backchannel_logout", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestBackchannelLogout_Type(t *testing.T) {
+	a := newBackchannelTestApplication(t)
+	// Untyped tokens and the media type of logout tokens are accepted
+	for _, typ := range []string{"", "logout+jwt", "application/logout+JWT"} {
+		rr := httptest.NewRecorder()
+		a.mux.ServeHTTP(rr, typedLogoutTokenRequest(t, a, logoutTokenClaimsFor(a, "foo"), typ))
+		assert.Equal(t, http.StatusOK, rr.Code, typ)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/gorilla/sessions"
 	"goauthentik.io/internal/outpost/proxyv2/constants"
 	"golang.org/x/oauth2"
 )
@@ -15,28 +16,58 @@ func (a *Application) handleAuthCallback(rw http.ResponseWriter, r *http.Request
 	state := a.stateFromRequest(r)
 	if state == nil {
 		a.log.Warning("invalid state")
-		a.redirect(rw, r)
+		a.redirect(rw, r, "")
 		return
 	}
 	claims, err := a.redeemCallback(r.URL, r.Context())
 	if err != nil {
 		a.log.WithError(err).Warning("failed to redeem code")
-		a.redirect(rw, r)
+		a.redirect(rw, r, "")
 		return
 	}
-	s, err := a.sessions.Get(r, a.SessionName())
+	s, err := a.getSession(r, a.SessionName())
 	if err != nil {
 		a.log.WithError(err).Trace("failed to get session")
 	}
-	s.Options.MaxAge = int(time.Until(time.Unix(int64(claims.Exp), 0)).Seconds())
-	s.Values[constants.SessionClaims] = &claims
-	err = s.Save(r, rw)
+	rd := a.consumeSessionRedirect(s)
+	claims.CreatedAt = time.Now().Unix()
+	claims.ExpiresAt = a.claimsDeadline(*claims)
+	err = a.authenticateSession(rw, r, s, *claims)
 	if err != nil {
 		a.log.WithError(err).Warning("failed to save session")
 		rw.WriteHeader(400)
 		return
 	}
-	a.redirect(rw, r)
+	a.setInfoCookie(rw, *s.Options, claims)
+	if _, err := a.enforceSessionLimit(r.Context(), claims.Sub, s.ID); err != nil {
+		a.log.WithError(err).Warning("failed to enforce session limit")
+	}
+	// The state is bound to the session ID from before the login, which was just renewed
+	a.redirectWithState(rw, r, state, rd)
+}
+
+// authenticateSession stores claims in s under a new session ID and deletes the session
+// stored under its previous ID, so that a session ID known before the login, for example
+// one planted by an attacker, doesn't become authenticated
+func (a *Application) authenticateSession(rw http.ResponseWriter, r *http.Request, s *sessions.Session, claims Claims) error {
+	s.Options.MaxAge = jitterMaxAge(a.sessionMaxAge(claims))
+	persisted := a.persistedClaims(claims)
+	s.Values[constants.SessionClaims] = &persisted
+	bindClientIP(r, s)
+	bindUserAgent(r, s)
+	previousID := s.ID
+	s.ID = a.taggedSessionID(renewedSessionID(previousID), claims)
+	if err := s.Save(r, rw); err != nil {
+		a.logSessionTooLong(s, err)
+		return err
+	}
+	if previousID == "" {
+		return nil
+	}
+	if err := a.LogoutSession(r.Context(), previousID); err != nil {
+		a.log.WithError(err).Warning("failed to delete session from before login")
+	}
+	return nil
 }
 
 func (a *Application) redeemCallback(u *url.URL, c context.Context) (*Claims, error) {
@@ -69,6 +100,13 @@ func (a *Application) redeemCallback(u *url.URL, c context.Context) (*Claims, er
 	if claims.Proxy == nil {
 		claims.Proxy = &ProxyClaims{}
 	}
+	var actor actorClaims
+	if err := idToken.Claims(&actor); err != nil {
+		return nil, err
+	}
+	if actor.Act != nil {
+		claims.ImpersonatorSubject = actor.Act.Sub
+	}
 	claims.RawToken = jwt
 	return claims, nil
 }
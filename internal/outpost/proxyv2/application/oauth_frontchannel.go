@@ -0,0 +1,28 @@
+package application
+
+import "net/http"
+
+// handleFrontchannelLogout handles OpenID Connect front-channel logout requests, which the
+// IdP loads in an iframe, by deleting the session of the current request
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html
+func (a *Application) handleFrontchannelLogout(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Cache-Control", "no-store")
+	s, err := a.getSession(r, a.SessionName())
+	if err != nil || s.IsNew {
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	// When the IdP sends a session ID, only the matching session is logged out
+	if sid := r.URL.Query().Get("sid"); sid != "" {
+		if c, ok := sessionClaims(s.Values); ok && c.Sid != sid {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	s.Options.MaxAge = -1
+	if err := s.Save(r, rw); err != nil {
+		a.log.WithError(err).Warning("failed to delete session")
+	}
+	a.clearInfoCookie(rw, *s.Options)
+	rw.WriteHeader(http.StatusOK)
+}
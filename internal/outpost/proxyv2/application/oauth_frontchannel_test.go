@@ -0,0 +1,46 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+)
+
+func TestFrontchannelLogout(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	rr := httptest.NewRecorder()
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo", Sid: "foo"}
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	cookie := rr.Result().Cookies()[0]
+
+	// A different session ID doesn't log out the session
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/frontchannel_logout?sid=bar", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	claims, err := a.Sessions(req.Context())
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/frontchannel_logout?sid=foo", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, -1, rr.Result().Cookies()[0].MaxAge)
+	claims, err = a.Sessions(req.Context())
+	assert.NoError(t, err)
+	assert.Len(t, claims, 0)
+}
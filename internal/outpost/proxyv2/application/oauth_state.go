@@ -10,8 +10,10 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
 	"github.com/mitchellh/mapstructure"
 	"goauthentik.io/api/v3"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
 )
 
 type OAuthState struct {
@@ -35,10 +37,19 @@ var base32RawStdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
 // the hostname matches what's configured, or no hostname must be given
 // For forward_domain this checks if the domain of the URL in `rd` ends with the configured domain
 func (a *Application) checkRedirectParam(r *http.Request) (string, bool) {
-	rd := r.URL.Query().Get(redirectParam)
+	return a.checkRedirectURL(r.URL.Query().Get(redirectParam))
+}
+
+// checkRedirectURL validates a URL to redirect to after login, as described for
+// checkRedirectParam. URLs longer than maxRedirectLength are rejected.
+func (a *Application) checkRedirectURL(rd string) (string, bool) {
 	if rd == "" {
 		return "", false
 	}
+	if len(rd) > maxRedirectLength {
+		a.log.WithField("length", len(rd)).Warning("redirect URL is too long")
+		return "", false
+	}
 	u, err := url.Parse(rd)
 	if err != nil {
 		a.log.WithError(err).Warning("Failed to parse redirect URL")
@@ -60,19 +71,51 @@ func (a *Application) checkRedirectParam(r *http.Request) (string, bool) {
 			return "", false
 		}
 	} else {
-		if !strings.HasSuffix(u.Host, *a.proxyConfig.CookieDomain) {
-			a.log.WithField("host", u.Host).WithField("dom", *a.proxyConfig.CookieDomain).Warning("redirect URI Host was not included in cookie domain")
+		if !strings.HasSuffix(u.Host, a.proxyConfig.GetCookieDomain()) {
+			a.log.WithField("host", u.Host).WithField("dom", a.proxyConfig.GetCookieDomain()).Warning("redirect URI Host was not included in cookie domain")
 			return "", false
 		}
 	}
 	return u.String(), true
 }
 
+// setSessionRedirect stores the URL originally requested before login in the session,
+// unless one is stored already. URLs longer than maxRedirectLength aren't stored, so that
+// they don't bloat the session. Returns whether the session was changed.
+func (a *Application) setSessionRedirect(s *sessions.Session, rd string) bool {
+	if _, redirectSet := s.Values[constants.SessionRedirect]; redirectSet {
+		return false
+	}
+	if len(rd) > maxRedirectLength {
+		a.log.WithField("length", len(rd)).Debug("not storing redirect URL, it is too long")
+		return false
+	}
+	s.Values[constants.SessionRedirect] = rd
+	return true
+}
+
+// consumeSessionRedirect removes the URL originally requested before login from the
+// session, and returns it if it is a valid redirect target
+func (a *Application) consumeSessionRedirect(s *sessions.Session) string {
+	v, _ := s.Values[constants.SessionRedirect].(string)
+	delete(s.Values, constants.SessionRedirect)
+	rd, ok := a.checkRedirectURL(v)
+	if !ok {
+		return ""
+	}
+	return rd
+}
+
 func (a *Application) createState(r *http.Request, fwd string) (string, error) {
-	s, _ := a.sessions.Get(r, a.SessionName())
+	s, _ := a.getSession(r, a.SessionName())
 	if s.ID == "" {
-		// Ensure session has an ID
-		s.ID = base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+		// Ensure session has an ID, the tenant is derived from the originally requested
+		// host, as the login can be started on a different host
+		host := r.Host
+		if u, err := url.Parse(fwd); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		s.ID = a.newSessionID(host)
 	}
 	st := &OAuthState{
 		Issuer:    fmt.Sprintf("goauthentik.io/outpost/%s", a.proxyConfig.GetClientId()),
@@ -116,7 +159,7 @@ func (a *Application) stateFromRequest(r *http.Request) *OAuthState {
 		a.log.WithError(err).Warning("failed to mapdecode")
 		return nil
 	}
-	s, _ := a.sessions.Get(r, a.SessionName())
+	s, _ := a.getSession(r, a.SessionName())
 	if claims.SessionID != s.ID {
 		a.log.WithField("is", claims.SessionID).WithField("should", s.ID).Warning("mismatched session ID")
 		return nil
@@ -1,15 +1,30 @@
 package application
 
 import (
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/sessions"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"goauthentik.io/api/v3"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/hs256"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
 )
 
 func TestCheckRedirectParam_None(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	// Test no rd param
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/start", nil)
 
@@ -20,7 +35,7 @@ func TestCheckRedirectParam_None(t *testing.T) {
 }
 
 func TestCheckRedirectParam_Invalid(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	// Test invalid rd param
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/start?rd=https://google.com", nil)
 
@@ -31,7 +46,7 @@ func TestCheckRedirectParam_Invalid(t *testing.T) {
 }
 
 func TestCheckRedirectParam_ValidFull(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	// Test valid full rd param
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/start?rd=https://ext.t.goauthentik.io/test?foo", nil)
 
@@ -42,7 +57,7 @@ func TestCheckRedirectParam_ValidFull(t *testing.T) {
 }
 
 func TestCheckRedirectParam_ValidPartial(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	// Test valid partial rd param
 	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/start?rd=/test?foo", nil)
 
@@ -53,7 +68,7 @@ func TestCheckRedirectParam_ValidPartial(t *testing.T) {
 }
 
 func TestCheckRedirectParam_Domain(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 	a.proxyConfig.CookieDomain = api.PtrString("t.goauthentik.io")
 	req, _ := http.NewRequest("GET", "https://a.t.goauthentik.io/outpost.goauthentik.io/auth/start", nil)
@@ -69,3 +84,182 @@ func TestCheckRedirectParam_Domain(t *testing.T) {
 	assert.Equal(t, true, ok)
 	assert.Equal(t, "https://ext.t.goauthentik.io/test", rd)
 }
+
+func TestCheckRedirectParam_TooLong(t *testing.T) {
+	a := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/outpost.goauthentik.io/auth/start?rd=/"+strings.Repeat("a", maxRedirectLength), nil)
+
+	rd, ok := a.checkRedirectParam(req)
+
+	assert.Equal(t, false, ok)
+	assert.Equal(t, "", rd)
+}
+
+func TestSessionRedirect(t *testing.T) {
+	a := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+
+	// Long URLs aren't stored
+	assert.False(t, a.setSessionRedirect(s, "/"+strings.Repeat("a", maxRedirectLength)))
+	assert.NotContains(t, s.Values, constants.SessionRedirect)
+
+	// The first URL is kept
+	assert.True(t, a.setSessionRedirect(s, "/app?foo"))
+	assert.False(t, a.setSessionRedirect(s, "/other"))
+	assert.Equal(t, "https://ext.t.goauthentik.io/app?foo", a.consumeSessionRedirect(s))
+	assert.NotContains(t, s.Values, constants.SessionRedirect)
+
+	// URLs of other hosts are rejected when they are consumed
+	assert.True(t, a.setSessionRedirect(s, "https://evil.example.com/app"))
+	assert.Equal(t, "", a.consumeSessionRedirect(s))
+	assert.NotContains(t, s.Values, constants.SessionRedirect)
+	assert.Equal(t, "", a.consumeSessionRedirect(s))
+}
+
+func TestHandleAuthStart_AuthCookieOptions(t *testing.T) {
+	a := newTestApplication(t)
+	config.Get().Proxy.AuthCookieSameSite = "none"
+	defer func() {
+		config.Get().Proxy.AuthCookieSameSite = ""
+	}()
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/start", nil)
+	rr := httptest.NewRecorder()
+	a.handleAuthStart(rr, req, "")
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, http.SameSiteNoneMode, cookies[0].SameSite)
+	assert.True(t, cookies[0].Secure)
+
+	// The options of the session cookie itself are unchanged
+	s, _ := a.sessions.New(req, a.SessionName())
+	assert.Equal(t, http.SameSiteLaxMode, s.Options.SameSite)
+}
+
+func TestAuthenticateSession(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/start", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.ID = a.newSessionID(req.Host)
+	s.Options.MaxAge = 600
+	rr := httptest.NewRecorder()
+	assert.NoError(t, s.Save(req, rr))
+	preAuth := rr.Result().Cookies()[0]
+
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/callback", nil)
+	req.AddCookie(preAuth)
+	s, err := a.sessions.Get(req, a.SessionName())
+	assert.NoError(t, err)
+	assert.False(t, s.IsNew)
+	previousID := s.ID
+	rr = httptest.NewRecorder()
+	assert.NoError(t, a.authenticateSession(rr, req, s, Claims{
+		Sub: "foo",
+		Exp: int(time.Now().Add(time.Hour).Unix()),
+	}))
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.NotEqual(t, preAuth.Value, cookies[0].Value)
+	assert.NotEqual(t, previousID, s.ID)
+
+	// The session from before the login is deleted, the new one is authenticated
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.AddCookie(preAuth)
+	s, _ = a.sessions.New(req, a.SessionName())
+	assert.NotContains(t, s.Values, constants.SessionClaims)
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.AddCookie(cookies[0])
+	assert.Equal(t, "foo", a.getClaimsFromSession(req).Sub)
+}
+
+func TestHandleAuthCallback(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": "http://fake-auth.t.goauthentik.io",
+		"aud": *a.proxyConfig.ClientId,
+		"sub": "foo",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": %q, "token_type": "bearer", "expires_in": 3600}`, token)
+	}))
+	defer ts.Close()
+	// Sessions of the test application expire right away
+	a.sessions.(*memorystore.MemoryStore).Options(sessions.Options{Path: "/", MaxAge: 86400})
+	a.oauthConfig.Endpoint.TokenURL = ts.URL
+	a.publicHostHTTPClient = ts.Client()
+	a.tokenVerifier = oidc.NewVerifier("http://fake-auth.t.goauthentik.io", hs256.NewKeySet("secret"), &oidc.Config{
+		ClientID:             *a.proxyConfig.ClientId,
+		SupportedSigningAlgs: []string{"HS256"},
+	})
+
+	rr := httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/start?rd=https://ext.t.goauthentik.io/foo", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	loc, err := rr.Result().Location()
+	assert.NoError(t, err)
+	preAuth := rr.Result().Cookies()[0]
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/callback?"+url.Values{
+		"code":  []string{"code"},
+		"state": []string{loc.Query().Get("state")},
+	}.Encode(), nil)
+	req.AddCookie(preAuth)
+	rr = httptest.NewRecorder()
+	a.mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "https://ext.t.goauthentik.io/foo", rr.Header().Get("Location"))
+
+	// The renewed session is authenticated
+	var session *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == a.SessionName() {
+			session = c
+		}
+	}
+	assert.NotNil(t, session)
+	assert.NotEqual(t, preAuth.Value, session.Value)
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.AddCookie(session)
+	c, ok := a.ClaimsFromRequest(req)
+	assert.True(t, ok)
+	assert.Equal(t, "foo", c.Sub)
+}
+
+func TestAuthenticateSession_TooLong(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionMaxLength = 2048
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionMaxLength = 0
+	}()
+	logger, hook := logtest.NewNullLogger()
+	a := newTestApplication(t, withLogger(logger))
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/outpost.goauthentik.io/callback", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	groups := make([]string, 100)
+	for i := range groups {
+		groups[i] = strings.Repeat("g", 32)
+	}
+	err := a.authenticateSession(httptest.NewRecorder(), req, s, Claims{
+		Sub:    "foo",
+		Exp:    int(time.Now().Add(time.Hour).Unix()),
+		Groups: groups,
+	})
+	assert.ErrorIs(t, err, filesystemstore.ErrSessionTooLong)
+	entry := hook.LastEntry()
+	assert.Equal(t, log.WarnLevel, entry.Level)
+	assert.Equal(t, "groups", entry.Data["claim"])
+}
@@ -2,199 +2,1672 @@ package application
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
-	"math"
+	"maps"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
 
 	"goauthentik.io/api/v3"
 	"goauthentik.io/internal/config"
 	"goauthentik.io/internal/outpost/proxyv2/codecs"
-	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
 	"goauthentik.io/internal/outpost/proxyv2/redisstore"
 	"goauthentik.io/internal/utils"
 )
 
 const RedisKeyPrefix = "authentik_proxy_session_"
 
+// redisDeleteBatchSize is the maximum number of keys deleted with a single pipeline
+const redisDeleteBatchSize = 100
+
+// defaultRedisTimeout is the timeout of individual Redis commands issued by Logout
+const defaultRedisTimeout = 5 * time.Second
+
+// redisRetries is how often a failed Redis command issued by Logout is retried
+const redisRetries = 3
+
+// defaultSessionCompressionThreshold is the size in bytes from which sessions are
+// compressed, smaller sessions barely shrink or even grow when compressed
+const defaultSessionCompressionThreshold = 1024
+
+// redisRetryBackoff is the delay before the first retry of a failed Redis command,
+// which doubles with every retry
+var redisRetryBackoff = 100 * time.Millisecond
+
+var redisURLPrecedence sync.Once
+
+const (
+	// SessionBackendRedis stores sessions in Redis, shared between all replicas
+	SessionBackendRedis = "redis"
+	// SessionBackendFilesystem stores sessions as files on the local filesystem
+	SessionBackendFilesystem = "filesystem"
+	// SessionBackendMemory keeps sessions in memory, for tests and ephemeral single-replica deployments
+	SessionBackendMemory = "memory"
+	// SessionBackendPostgres stores sessions in the PostgreSQL database of authentik, shared
+	// between all replicas
+	SessionBackendPostgres = "postgres"
+)
+
+var (
+	// ErrUnsupportedBackend is returned by getStore when the configured session backend is unknown
+	ErrUnsupportedBackend = errors.New("unsupported session backend")
+	// ErrInvalidTLSConfig is returned by getStore when the Redis TLS settings are invalid
+	ErrInvalidTLSConfig = errors.New("invalid redis TLS configuration")
+	// ErrRedisUnavailable is returned by getStore when Redis can't be reached
+	ErrRedisUnavailable = errors.New("redis is unavailable")
+	// ErrInvalidRedisURL is returned by getStore when the Redis URL can't be parsed
+	ErrInvalidRedisURL = errors.New("invalid redis url, must be a redis:// or rediss:// url")
+	// ErrInvalidSessionInstance is returned by getStore when the session instance isn't a
+	// valid directory name
+	ErrInvalidSessionInstance = errors.New("invalid session instance, must be a single directory name")
+	// ErrInvalidCookieOptions is returned by getStore when browsers would reject the
+	// session cookie because of incompatible cookie options
+	ErrInvalidCookieOptions = errors.New("invalid cookie options")
+	// ErrInvalidCookiePrefix is returned by cookieNamePrefix when the cookie prefix is unknown
+	ErrInvalidCookiePrefix = errors.New("invalid cookie prefix, must be one of host or secure")
+	// ErrInvalidSessionIPBinding is returned by sessionIPBinding when the binding is unknown
+	ErrInvalidSessionIPBinding = errors.New("invalid session ip binding, must be one of ip or subnet")
+	// ErrSharedKeyPrefix is returned by LogoutAll when the Redis key prefix of the
+	// application's sessions may be shared with other applications
+	ErrSharedKeyPrefix = errors.New("redis session key prefix is shared with other applications")
+	// ErrPostgresUnavailable is returned by getStore when PostgreSQL can't be reached or
+	// the session table can't be created
+	ErrPostgresUnavailable = errors.New("postgresql is unavailable")
+	// ErrInvalidPostgresConfig is returned by getStore when the PostgreSQL settings are invalid
+	ErrInvalidPostgresConfig = errors.New("invalid postgresql configuration")
+)
+
+const (
+	// cookiePrefixHost requires the cookie to be Secure, with Path=/ and without a Domain
+	cookiePrefixHost = "__Host-"
+	// cookiePrefixSecure requires the cookie to be Secure
+	cookiePrefixSecure = "__Secure-"
+)
+
+// redisKeyPrefix returns the prefix under which sessions are stored in Redis,
+// which can be overridden so that outposts or applications sharing a Redis instance
+// don't see each other's sessions. With SessionKeyHashTag, the part of the prefix
+// specific to the application is wrapped in a hash tag, so that all sessions of the
+// application are in the same Redis Cluster slot.
+func (a *Application) redisKeyPrefix() string {
+	prefix := a.sessionKeyPrefix()
+	if !config.Get().Proxy.SessionKeyHashTag {
+		if prefix != "" {
+			return prefix
+		}
+		return RedisKeyPrefix
+	}
+	switch {
+	case redisstore.HashTag(prefix) != "":
+		return prefix
+	case prefix != "":
+		return "{" + prefix + "}"
+	default:
+		return RedisKeyPrefix + "{" + a.proxyConfig.AssignedApplicationSlug + "}_"
+	}
+}
+
+// redisKeyPrefixShared returns whether other applications may store their sessions under
+// the Redis key prefix of this application's sessions. The prefix belongs to the
+// application when the prefix, the Redis database or the Redis server of its sessions is
+// overridden for the application, or when SessionKeyHashTag wraps its slug in the prefix.
+func (a *Application) redisKeyPrefixShared() bool {
+	slug := a.proxyConfig.AssignedApplicationSlug
+	ac := config.Get().Proxy.Applications[slug]
+	if ac.SessionKeyPrefix != "" || ac.SessionRedisDB != nil || ac.SessionRedis != nil {
+		return false
+	}
+	return !config.Get().Proxy.SessionKeyHashTag || a.sessionKeyPrefix() != "" || slug == ""
+}
+
+// sessionKeyPrefix returns the configured Redis key prefix of this application's sessions.
+// A prefix set in the outpost configuration in authentik replaces the local prefix of the
+// outpost, while a local prefix of the application takes precedence over both.
+func (a *Application) sessionKeyPrefix() string {
+	slug := a.proxyConfig.AssignedApplicationSlug
+	if prefix := config.Get().Proxy.Applications[slug].SessionKeyPrefix; prefix != "" {
+		return prefix
+	}
+	if prefix, ok := a.remoteSessionKeyPrefix(); ok {
+		return prefix
+	}
+	return config.Get().Proxy.SessionKeyPrefix
+}
+
+// getStore creates the session store of the application, counting failures by their reason
 func (a *Application) getStore(p api.ProxyOutpostConfig, externalHost *url.URL) (sessions.Store, error) {
+	store, err := a.newStore(p, externalHost)
+	if err != nil {
+		a.countStoreInitFailure(err)
+	}
+	return store, err
+}
+
+func (a *Application) newStore(p api.ProxyOutpostConfig, externalHost *url.URL) (sessions.Store, error) {
 	maxAge := 0
 	if p.AccessTokenValidity.IsSet() {
 		t := p.AccessTokenValidity.Get()
 		// Add one to the validity to ensure we don't have a session with indefinite length
 		maxAge = int(*t) + 1
 	}
+	ac := config.Get().Proxy.ForApplication(p.AssignedApplicationSlug)
+	secure := a.getSecure(ac.CookieForceSecure, externalHost)
+	cookiePath := "/"
+	if ac.CookiePath != "" {
+		cookiePath = ac.CookiePath
+	}
+	// An empty cookie domain makes the session cookie host-only, as the Domain attribute is
+	// omitted and the cookie isn't shared with subdomains
+	cookieDomain := strings.TrimSpace(p.GetCookieDomain())
+	if p.CookieDomain == nil && a.cookiePrefix() != cookiePrefixHost {
+		cookieDomain = externalHost.Hostname()
+		a.log.WithField("domain", cookieDomain).Warning("no cookie domain set, using external host")
+	}
+	if limit := int(ac.SessionMaxAge.Seconds()); limit > 0 && (maxAge == 0 || limit < maxAge) {
+		maxAge = limit
+	}
+	if idle := int(ac.IdleTimeout.Seconds()); idle > 0 && (maxAge == 0 || idle < maxAge) {
+		maxAge = idle
+	}
+	opts := sessions.Options{
+		HttpOnly: true,
+		Secure:   secure,
+		Domain:   cookieDomain,
+		SameSite: a.getSameSite(ac.CookieSameSite, secure),
+		MaxAge:   maxAge,
+		Path:     cookiePath,
+	}
+	if err := a.validateCookieOptions(ac, opts); err != nil {
+		return nil, err
+	}
+	backend := a.sessionBackend()
+	factory, ok := sessionStoreFactory(backend)
+	if !ok {
+		return nil, fmt.Errorf("%w %q, must be one of %s", ErrUnsupportedBackend, backend, strings.Join(sessionStoreNames(), ", "))
+	}
+	if backend == SessionBackendFilesystem && config.Get().Proxy.SessionBackend == "" {
+		a.log.Warning("no session backend configured, storing sessions in files, sessions are lost when the outpost restarts unless the session directory is persisted")
+	}
+	store, err := factory(SessionStoreConfig{
+		Application: a,
+		Provider:    p,
+		Options:     opts,
+	})
+	if backend == SessionBackendRedis && errors.Is(err, ErrRedisUnavailable) {
+		store, backend, err = a.getFallbackStore(p, maxAge, opts, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.logStoreOptions(store, backend, opts)
+	a.setEffectiveBackend(backend)
+	return store, nil
+}
+
+// logStoreOptions logs the backend and the effective cookie options of the session store,
+// to help diagnose session cookies which aren't set or aren't sent by browsers
+func (a *Application) logStoreOptions(store sessions.Store, backend string, opts sessions.Options) {
+	fields := log.Fields{
+		"backend":   backend,
+		"name":      a.SessionName(),
+		"secure":    opts.Secure,
+		"domain":    opts.Domain,
+		"same_site": sameSiteName(opts.SameSite),
+		"path":      opts.Path,
+		"max_age":   opts.MaxAge,
+		"http_only": opts.HttpOnly,
+	}
+	switch s := store.(type) {
+	case *redisstore.RedisStore:
+		fields["key_prefix"] = a.redisKeyPrefix()
+	case *filesystemstore.FilesystemStore:
+		fields["dir"] = s.Path()
+		fields["key_prefix"] = s.Prefix()
+	case *postgresstore.PostgresStore:
+		fields["namespace"] = a.postgresNamespace()
+	}
+	a.log.WithFields(fields).Debug("created session store")
+}
+
+// sameSiteName returns the name of a SameSite attribute as it is set on cookies
+func sameSiteName(sameSite http.SameSite) string {
+	switch sameSite {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	default:
+		return "default"
+	}
+}
+
+// authCookieOptions returns the cookie options of a session while the user is being
+// authenticated, derived from the options of the session cookie. A separate SameSite
+// policy allows the cookie to be sent along with cross-site callbacks from the IdP.
+func (a *Application) authCookieOptions(opts sessions.Options) sessions.Options {
+	ac := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug)
+	if ac.AuthCookieForceSecure != "" {
+		if externalHost, err := url.Parse(a.proxyConfig.ExternalHost); err == nil {
+			opts.Secure = a.getSecure(ac.AuthCookieForceSecure, externalHost)
+		}
+	}
+	if a.cookiePrefix() != "" {
+		// Prefixed cookies are rejected by browsers unless they are secure
+		opts.Secure = true
+	}
+	if ac.AuthCookieSameSite != "" {
+		opts.SameSite = a.getSameSite(ac.AuthCookieSameSite, opts.Secure)
+	}
+	return opts
+}
+
+// idleTimeout returns the configured idle timeout of this application's sessions
+func (a *Application) idleTimeout() time.Duration {
+	return config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).IdleTimeout
+}
+
+// sessionDeadline returns the absolute deadline of a session created at the Unix time
+// created, which is zero when no session max age is configured
+func (a *Application) sessionDeadline(created int64) int64 {
+	limit := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionMaxAge
+	if limit <= 0 {
+		return 0
+	}
+	return time.Unix(created, 0).Add(limit).Unix()
+}
+
+// sessionMaxAge returns the max age of a session with claims c, which is the time until
+// its claims expire, limited by the deadline of the session. Sessions created before their
+// deadline was recorded are limited by the session max age since they were created. With
+// an idle timeout, the session expires after the idle timeout, but never after the claims.
+func (a *Application) sessionMaxAge(c Claims) int {
+	maxAge := int(time.Until(time.Unix(int64(c.Exp), 0)).Seconds())
+	deadline := c.ExpiresAt
+	if deadline == 0 {
+		created := c.CreatedAt
+		if created == 0 {
+			created = time.Now().Unix()
+		}
+		deadline = a.sessionDeadline(created)
+	}
+	if deadline != 0 {
+		maxAge = min(maxAge, int(time.Until(time.Unix(deadline, 0)).Seconds()))
+	}
+	if idle := int(a.idleTimeout().Seconds()); idle > 0 && idle < maxAge {
+		return idle
+	}
+	return maxAge
+}
+
+// jitterMaxAge shortens maxAge by a random amount of up to the configured fraction, so
+// that sessions created at the same time don't all expire at once. The result is never
+// longer than maxAge, and therefore never outlives the access token.
+func jitterMaxAge(maxAge int) int {
+	jitter := min(config.Get().Proxy.SessionExpiryJitter, 1)
+	spread := int(float64(maxAge) * jitter)
+	if spread <= 0 {
+		return maxAge
+	}
+	return maxAge - rand.IntN(spread)
+}
+
+// refreshSession slides the expiry of the current session forward on activity when an
+// idle timeout is configured. Saving the session also resets the TTL of Redis sessions.
+func (a *Application) refreshSession(rw http.ResponseWriter, r *http.Request, c *Claims) {
+	if a.idleTimeout() <= 0 || c.Exp == 0 {
+		return
+	}
+	s, err := a.getSession(r, a.SessionName())
+	if err != nil {
+		return
+	}
+	s.Options.MaxAge = a.sessionMaxAge(*c)
+	if err := s.Save(r, rw); err != nil {
+		a.log.WithError(err).Warning("failed to refresh session")
+		return
+	}
+	a.setInfoCookie(rw, *s.Options, c)
+}
+
+// sessionBackend returns the configured session backend. When none is configured,
+// the embedded outpost uses Redis and other outposts use the filesystem
+func (a *Application) sessionBackend() string {
+	if backend := config.Get().Proxy.SessionBackend; backend != "" {
+		return strings.ToLower(backend)
+	}
 	if a.isEmbedded {
-		var tls *tls.Config
-		if config.Get().Redis.TLS {
-			tls = utils.GetTLSConfig()
-			switch strings.ToLower(config.Get().Redis.TLSReqs) {
-			case "none":
-			case "false":
-				tls.InsecureSkipVerify = true
-			case "required":
-				break
+		return SessionBackendRedis
+	}
+	return SessionBackendFilesystem
+}
+
+func newMemoryStore(opts sessions.Options) *memorystore.MemoryStore {
+	ms := memorystore.NewMemoryStore()
+	ms.Options(opts)
+	ms.Serializer(getSessionSerializer())
+	return ms
+}
+
+func (a *Application) getRedisStore(opts sessions.Options) (sessions.Store, error) {
+	tlsConfig, err := a.getRedisTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := a.getRedisClient(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	client.AddHook(redisLatencyHook{a: a})
+
+	// New default RedisStore
+	rs, err := redisstore.NewRedisStore(context.Background(), client)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRedisUnavailable, err)
+	}
+	readClient, err := a.getRedisReadClient(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if readClient != nil {
+		readClient.AddHook(redisLatencyHook{a: a})
+		// Sessions are loaded from the primary while the replica is unavailable
+		if err := readClient.Ping(context.Background()).Err(); err != nil {
+			a.log.WithError(err).Warning("failed to connect to redis replica")
+		}
+		rs.ReadClient(readClient)
+	}
+
+	rs.KeyPrefix(a.redisKeyPrefix())
+	rs.StorageID(a.storageID)
+	rs.PreviousStorageIDs(a.previousStorageIDs)
+	rs.Options(opts)
+	rs.Serializer(a.redisSerializer(client))
+	rs.LinkedKeys(func(key string) []string {
+		return []string{claimsBlobKey(key)}
+	})
+	rs.ScanTimeout(redisTimeout())
+	rs.MaxLength(config.Get().Proxy.SessionMaxLength)
+
+	if config.Get().Proxy.SessionMigrateFilesystem {
+		rs.CookieCodecs(cookieSecretCodecs(opts.MaxAge, a.proxyConfig)...)
+		migrated, err := a.migrateFilesystemSessions(context.Background(), rs, opts)
+		if err != nil {
+			a.log.WithError(err).Warning("failed to migrate filesystem sessions")
+		}
+		a.log.WithField("migrated", migrated).Info("migrated filesystem sessions to redis")
+	}
+
+	return rs, nil
+}
+
+// redisSerializer returns the serializer of sessions stored in Redis with the given client
+func (a *Application) redisSerializer(client redis.UniversalClient) redisstore.SessionSerializer {
+	return claimsBlobSerializer{
+		SessionSerializer: getSessionSerializer(),
+		client:            client,
+		keyPrefix:         a.redisKeyPrefix(),
+	}
+}
+
+// getRedisTLSConfig returns the TLS config used to connect to Redis, or nil when TLS is disabled
+func (a *Application) getRedisTLSConfig() (*tls.Config, error) {
+	rc := a.redisConfig()
+	if !rc.TLS {
+		return nil, nil
+	}
+	if rc.URL == "" && rc.GetSocketPath() != "" {
+		a.log.Debug("redis is connected to with a unix socket, not using tls")
+		return nil, nil
+	}
+	tlsConfig := utils.GetTLSConfig()
+	// The requirements mirror ssl_cert_reqs of the Redis client used by authentik core,
+	// with verify-ca and verify-full modeled on libpq's sslmode. The certificate is
+	// verified unless verification is turned off explicitly, as it always was by the outpost
+	switch reqs := strings.ToLower(rc.TLSReqs); reqs {
+	case "false":
+		a.log.Warning("not verifying the certificate of the redis server, as redis tls_reqs is false")
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca":
+		// Verify the certificate chain but not the hostname
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyCertificateChain(cs, tlsConfig.RootCAs)
+		}
+	case "", "none", "optional", "required", "verify-full":
+	default:
+		return nil, fmt.Errorf("%w: unknown requirement %q, must be one of none, optional, required, verify-ca, verify-full or false", ErrInvalidTLSConfig, reqs)
+	}
+	ca, caData := rc.TLSCaCert, rc.TLSCaCertData
+	if ca != "" || caData != "" {
+		// Get the SystemCertPool, continue with an empty pool on error
+		rootCAs, _ := x509.SystemCertPool()
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if ca != "" {
+			certs, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to read CA %s: %w", ErrInvalidTLSConfig, ca, err)
 			}
-			ca := config.Get().Redis.TLSCaCert
-			if ca != "" {
-				// Get the SystemCertPool, continue with an empty pool on error
-				rootCAs, _ := x509.SystemCertPool()
-				if rootCAs == nil {
-					rootCAs = x509.NewCertPool()
-				}
-				certs, err := os.ReadFile(ca)
-				if err != nil {
-					a.log.WithError(err).Fatalf("Failed to append %s to RootCAs", ca)
-				}
-				// Append our cert to the system pool
-				if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
-					a.log.Println("No certs appended, using system certs only")
-				}
-				tls.RootCAs = rootCAs
+			// Append our cert to the system pool
+			if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
+				a.log.Println("No certs appended, using system certs only")
 			}
 		}
-		client := redis.NewClient(&redis.Options{
-			Addr:      fmt.Sprintf("%s:%d", config.Get().Redis.Host, config.Get().Redis.Port),
-			Username:  config.Get().Redis.Username,
-			Password:  config.Get().Redis.Password,
-			DB:        config.Get().Redis.DB,
-			TLSConfig: tls,
-		})
-
-		// New default RedisStore
-		rs, err := redisstore.NewRedisStore(context.Background(), client)
+		if caData != "" {
+			if ok := rootCAs.AppendCertsFromPEM([]byte(caData)); !ok {
+				a.log.Println("No certs appended from inline CA, using system certs only")
+			}
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	cert, key := rc.TLSClientCert, rc.TLSClientKey
+	if (cert == "") != (key == "") {
+		return nil, fmt.Errorf("%w: both a client certificate and key have to be configured", ErrInvalidTLSConfig)
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%w: failed to load client certificate: %w", ErrInvalidTLSConfig, err)
 		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return tlsConfig, nil
+}
 
-		rs.KeyPrefix(RedisKeyPrefix)
-		rs.Options(sessions.Options{
-			HttpOnly: true,
-			Secure:   strings.ToLower(externalHost.Scheme) == "https",
-			Domain:   *p.CookieDomain,
-			SameSite: http.SameSiteLaxMode,
-			MaxAge:   maxAge,
-			Path:     "/",
-		})
+// verifyCertificateChain verifies the peer certificate chain against roots, without
+// checking the hostname. When roots is nil, the system roots are used
+func verifyCertificateChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no peer certificate presented")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// defaultFilesystemSessionMaxLength is the maximum length of encoded session files when
+// SessionMaxLength isn't set
+const defaultFilesystemSessionMaxLength = 1 << 20
+
+// logSessionTooLong logs the largest claim of s when err is caused by s exceeding the
+// maximum session length, so that the scopes or claims mapped into the token can be trimmed
+func (a *Application) logSessionTooLong(s *sessions.Session, err error) {
+	if !errors.Is(err, filesystemstore.ErrSessionTooLong) {
+		return
+	}
+	l := a.log.WithError(err)
+	if c, ok := sessionClaims(s.Values); ok {
+		name, length := largestClaim(c)
+		l = l.WithField("claim", name).WithField("claim_length", length)
+	}
+	l.Warning("session is too long to be stored, reduce the claims of the token")
+}
 
-		a.log.Trace("using redis session backend")
-		return rs, nil
+func (a *Application) getFilesystemStore(p api.ProxyOutpostConfig, maxAge int, opts sessions.Options) (sessions.Store, error) {
+	dir, err := getSessionDir()
+	if err != nil {
+		return nil, err
+	}
+	cs := filesystemstore.NewFilesystemStore(dir)
+	if slug := p.AssignedApplicationSlug; slug != "" {
+		// Slugs can't contain a period, so applications whose slug begins with another
+		// application's slug don't share a prefix
+		cs.FilePrefix(filesystemstore.SessionFilePrefix + slug + ".")
+		// Sessions of applications were stored without the slug in their file name before
+		cs.LegacyFilePrefixes(filesystemstore.SessionFilePrefix)
+	}
+	cs.Codecs = cookieSecretCodecs(maxAge, p)
+	cs.StorageID(a.storageID)
+	cs.PreviousStorageIDs(a.previousStorageIDs)
+	cs.Compression(config.Get().Proxy.SessionCompression)
+	cs.CompressionThreshold(sessionCompressionThreshold())
+	cs.ObserveLatency(a.observeStoreLatency)
+	if key := config.Get().Proxy.SessionEncryptionKey; key != "" {
+		if err := cs.EncryptionKey([]byte(key)); err != nil {
+			return nil, err
+		}
 	}
-	dir := os.TempDir()
-	cs := sessions.NewFilesystemStore(dir)
-	cs.Codecs = codecs.CodecsFromPairs(maxAge, []byte(*p.CookieSecret))
 	// https://github.com/markbates/goth/commit/7276be0fdf719ddff753f3574ef0f967e4a5a5f7
 	// set the maxLength of the cookies stored on the disk to a larger number to prevent issues with:
 	// securecookie: the value is too long
 	// when using OpenID Connect, since this can contain a large amount of extra information in the id_token
+	// The length is still bounded, so that oversized tokens can't fill the disk
 
 	// Note, when using the FilesystemStore only the session.ID is written to a browser cookie, so this is explicit for the storage on disk
-	cs.MaxLength(math.MaxInt)
-	cs.Options.HttpOnly = true
-	cs.Options.Secure = strings.ToLower(externalHost.Scheme) == "https"
-	cs.Options.Domain = *p.CookieDomain
-	cs.Options.SameSite = http.SameSiteLaxMode
-	cs.Options.MaxAge = maxAge
-	cs.Options.Path = "/"
-	a.log.WithField("dir", dir).Trace("using filesystem session backend")
+	maxLength := defaultFilesystemSessionMaxLength
+	if l := config.Get().Proxy.SessionMaxLength; l > 0 {
+		maxLength = l
+	} else if l < 0 {
+		maxLength = 0
+	}
+	cs.MaxLength(maxLength)
+	cs.Options = &opts
 	return cs, nil
 }
 
+// getSecure returns whether the session cookie should have the Secure attribute. By default
+// this is detected from the scheme of the external host, which can be overridden for setups
+// where the scheme the browser sees differs from the external host
+func (a *Application) getSecure(force string, externalHost *url.URL) bool {
+	switch strings.ToLower(force) {
+	case "", "auto":
+		return strings.ToLower(externalHost.Scheme) == "https"
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		a.log.WithField("cookie_force_secure", force).Warning("invalid cookie secure override, using auto")
+		return strings.ToLower(externalHost.Scheme) == "https"
+	}
+}
+
+// validateCookieOptions returns an error describing the incompatible options when browsers
+// would reject the session cookie set with opts, for example because SameSite=None or a
+// cookie prefix requires the Secure attribute, so that the misconfiguration surfaces when
+// the application is started instead of as a login loop.
+func (a *Application) validateCookieOptions(ac config.ProxyApplicationConfig, opts sessions.Options) error {
+	if strings.EqualFold(ac.CookieSameSite, "none") && !opts.Secure {
+		return fmt.Errorf("%w: SameSite=None requires a secure cookie, use an https external host or set cookie_force_secure", ErrInvalidCookieOptions)
+	}
+	if strings.EqualFold(ac.AuthCookieSameSite, "none") && !a.authCookieOptions(opts).Secure {
+		return fmt.Errorf("%w: SameSite=None requires a secure cookie, use an https external host or set auth_cookie_force_secure", ErrInvalidCookieOptions)
+	}
+	switch prefix := a.cookiePrefix(); prefix {
+	case cookiePrefixHost:
+		if opts.Domain != "" {
+			return fmt.Errorf("%w: %s cookies can't have a domain, clear the cookie domain of the provider", ErrInvalidCookieOptions, prefix)
+		}
+		if opts.Path != "/" {
+			return fmt.Errorf("%w: %s cookies require the path /, remove cookie_path", ErrInvalidCookieOptions, prefix)
+		}
+		fallthrough
+	case cookiePrefixSecure:
+		if !opts.Secure {
+			return fmt.Errorf("%w: %s cookies must be secure, use an https external host or set cookie_force_secure", ErrInvalidCookieOptions, prefix)
+		}
+	}
+	return nil
+}
+
+// getSameSite maps the configured SameSite policy to its cookie attribute. Browsers
+// reject SameSite=None cookies without the Secure attribute, so lax is used instead
+// when the application isn't served over https
+func (a *Application) getSameSite(policy string, secure bool) http.SameSite {
+	switch strings.ToLower(policy) {
+	case "", "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		if !secure {
+			a.log.Warning("SameSite=None requires https, falling back to lax")
+			return http.SameSiteLaxMode
+		}
+		return http.SameSiteNoneMode
+	default:
+		a.log.WithField("same_site", policy).Warning("invalid SameSite policy, falling back to lax")
+		return http.SameSiteLaxMode
+	}
+}
+
+// getRedisClient returns a client for the configured Redis server, going through
+// Redis Cluster or Redis Sentinel when configured
+func (a *Application) getRedisClient(tlsConfig *tls.Config) (redis.UniversalClient, error) {
+	rc := a.redisConfig()
+	if len(rc.ClusterAddresses) > 0 {
+		a.log.WithField("addresses", rc.ClusterAddresses).Trace("using redis cluster")
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:               rc.ClusterAddresses,
+			ReadOnly:            rc.ClusterReadOnly,
+			RouteByLatency:      rc.ClusterRouteByLatency,
+			CredentialsProvider: a.redisCredentials(),
+			TLSConfig:           tlsConfig,
+			PoolSize:            rc.PoolSize,
+			MinIdleConns:        rc.MinIdleConns,
+			DialTimeout:         rc.DialTimeout,
+			ReadTimeout:         rc.ReadTimeout,
+			WriteTimeout:        rc.WriteTimeout,
+		}), nil
+	}
+	if rc.SentinelMasterName != "" && len(rc.SentinelAddresses) > 0 {
+		// go-redis doesn't support a credentials provider for sentinel, so rotated
+		// credentials are only picked up after a restart
+		a.log.WithField("master", rc.SentinelMasterName).Trace("using redis sentinel")
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    rc.SentinelMasterName,
+			SentinelAddrs: rc.SentinelAddresses,
+			Username:      rc.Username,
+			Password:      rc.GetPassword(),
+			DB:            a.redisDB(),
+			TLSConfig:     tlsConfig,
+			PoolSize:      rc.PoolSize,
+			MinIdleConns:  rc.MinIdleConns,
+			DialTimeout:   rc.DialTimeout,
+			ReadTimeout:   rc.ReadTimeout,
+			WriteTimeout:  rc.WriteTimeout,
+		}), nil
+	}
+	opts, err := a.redisOptions(rc, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(opts), nil
+}
+
+// getRedisReadClient returns a client for the configured read replica, which uses the
+// same settings as the client of a single Redis server, or nil when no replica is set.
+// Replicas aren't configured this way with Redis Cluster or Redis Sentinel.
+func (a *Application) getRedisReadClient(tlsConfig *tls.Config) (redis.UniversalClient, error) {
+	rc := a.redisConfig()
+	if rc.ReplicaAddress == "" {
+		return nil, nil
+	}
+	if len(rc.ClusterAddresses) > 0 || (rc.SentinelMasterName != "" && len(rc.SentinelAddresses) > 0) {
+		a.log.Warning("a redis replica address is only supported with a single redis server, ignoring it")
+		return nil, nil
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+	}
+	opts, err := a.redisOptions(rc, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts.Network = "tcp"
+	opts.Addr = rc.ReplicaAddress
+	if opts.TLSConfig != nil {
+		// Verify the certificate against the replica's host instead of the primary's
+		opts.TLSConfig = opts.TLSConfig.Clone()
+		opts.TLSConfig.ServerName = ""
+	}
+	a.log.WithField("address", rc.ReplicaAddress).Trace("loading sessions from redis replica")
+	return redis.NewClient(opts), nil
+}
+
+// redisOptions returns the options of a client connecting to a single Redis server,
+// either from the connection URL or the discrete connection settings
+func (a *Application) redisOptions(rc config.RedisConfig, tlsConfig *tls.Config) (*redis.Options, error) {
+	if rc.URL != "" {
+		return a.redisURLOptions(rc, tlsConfig)
+	}
+	network, addr := "tcp", fmt.Sprintf("%s:%d", rc.Host, rc.Port)
+	if socket := rc.GetSocketPath(); socket != "" {
+		network, addr = "unix", socket
+	}
+	return &redis.Options{
+		Network: network,
+		Addr:    addr,
+		// Credentials are loaded for every new connection, so that rotated credentials
+		// are used when reconnecting
+		CredentialsProvider: a.redisCredentials(),
+		DB:                  a.redisDB(),
+		TLSConfig:           tlsConfig,
+		PoolSize:            rc.PoolSize,
+		MinIdleConns:        rc.MinIdleConns,
+		DialTimeout:         rc.DialTimeout,
+		ReadTimeout:         rc.ReadTimeout,
+		WriteTimeout:        rc.WriteTimeout,
+	}, nil
+}
+
+// redisURLOptions returns the options of a client connecting to rc.URL. The URL takes
+// precedence over the discrete connection settings, only the pool settings and the per
+// application database are applied when the URL doesn't set them. rediss:// URLs connect
+// with TLS, using tlsConfig when TLS is also configured with the discrete settings.
+func (a *Application) redisURLOptions(rc config.RedisConfig, tlsConfig *tls.Config) (*redis.Options, error) {
+	opts, err := redis.ParseURL(rc.URL)
+	if err != nil {
+		// The error can contain the URL including its credentials, so it isn't returned
+		return nil, ErrInvalidRedisURL
+	}
+	if rc.Username != "" || rc.Password != "" || rc.PasswordFile != "" || rc.DB != 0 || rc.TLS ||
+		(rc.Host != "" && rc.Host != "localhost") || (rc.Port != 0 && rc.Port != 6379) {
+		redisURLPrecedence.Do(func() {
+			a.log.Info("both a redis url and discrete redis connection settings are set, using the url")
+		})
+	}
+	if opts.TLSConfig != nil && tlsConfig != nil {
+		tlsConfig.ServerName = opts.TLSConfig.ServerName
+		opts.TLSConfig = tlsConfig
+	}
+	if db := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionRedisDB; db != nil {
+		opts.DB = *db
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = rc.PoolSize
+	}
+	if opts.MinIdleConns == 0 {
+		opts.MinIdleConns = rc.MinIdleConns
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = rc.DialTimeout
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = rc.ReadTimeout
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = rc.WriteTimeout
+	}
+	return opts, nil
+}
+
+// redisDB returns the Redis database in which this application's sessions are stored,
+// which can be overridden locally or in the outpost configuration in authentik, to fully
+// isolate the sessions of outposts sharing a Redis server
+func (a *Application) redisDB() int {
+	slug := a.proxyConfig.AssignedApplicationSlug
+	if db := config.Get().Proxy.Applications[slug].SessionRedisDB; db != nil {
+		return *db
+	}
+	ac := config.Get().Proxy.ForApplication(slug)
+	// The database set in authentik only applies to the Redis server of the outpost
+	if ac.SessionRedis == nil {
+		if db, ok := a.remoteSessionRedisDB(); ok {
+			return db
+		}
+	}
+	if ac.SessionRedisDB != nil {
+		return *ac.SessionRedisDB
+	}
+	return a.redisConfig().DB
+}
+
+// redisConfig returns the settings of the Redis server in which this application's
+// sessions are stored, which can be overridden to store them in a dedicated Redis server
+func (a *Application) redisConfig() config.RedisConfig {
+	if rc := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionRedis; rc != nil {
+		return *rc
+	}
+	return config.Get().Redis
+}
+
+// redisCredentials returns the function with which Redis clients load the credentials of
+// the Redis server of this application whenever they connect
+func (a *Application) redisCredentials() func() (string, string) {
+	rc := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionRedis
+	if rc == nil {
+		return config.RedisCredentials
+	}
+	return func() (string, string) {
+		return rc.Username, rc.GetPassword()
+	}
+}
+
+// redisTimeout returns the configured timeout of individual Redis commands issued by Logout
+func redisTimeout() time.Duration {
+	if t := config.Get().Proxy.SessionRedisTimeout; t > 0 {
+		return t
+	}
+	return defaultRedisTimeout
+}
+
+// withRedisRetry calls fn with a context bounded by redisTimeout, and retries it with
+// exponential backoff when it fails. redis.Nil is returned without retrying, and no more
+// retries are made once ctx is done.
+func withRedisRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		cmdCtx, cancel := context.WithTimeout(ctx, redisTimeout())
+		err := fn(cmdCtx)
+		cancel()
+		if err == nil || errors.Is(err, redis.Nil) || attempt >= redisRetries || !redisBackoff(ctx, attempt) {
+			return err
+		}
+	}
+}
+
+// redisBackoff waits before the given retry of a failed Redis command, and returns false
+// if ctx is done first
+func redisBackoff(ctx context.Context, attempt int) bool {
+	t := time.NewTimer(redisRetryBackoff << attempt)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// getSessionSerializer returns the configured serializer for sessions stored in Redis and memory
+func getSessionSerializer() redisstore.SessionSerializer {
+	var serializer redisstore.SessionSerializer = redisstore.GobSerializer{}
+	if strings.ToLower(config.Get().Proxy.SessionSerializer) == "json" {
+		serializer = redisstore.JSONSerializer{}
+	}
+	if config.Get().Proxy.SessionCompression {
+		return redisstore.CompressedSerializer{Serializer: serializer, Threshold: sessionCompressionThreshold()}
+	}
+	return serializer
+}
+
+// sessionCompressionThreshold returns the size in bytes from which sessions are compressed
+// when compression is enabled
+func sessionCompressionThreshold() int {
+	threshold := config.Get().Proxy.SessionCompressionThreshold
+	if threshold < 0 {
+		return 0
+	}
+	if threshold == 0 {
+		return defaultSessionCompressionThreshold
+	}
+	return threshold
+}
+
+// getSessionDir returns the directory filesystem sessions are stored in, creating it
+// if required and ensuring it is writable. With a session instance, sessions are stored
+// in a subdirectory named after it, so replicas sharing a directory don't see each
+// other's sessions.
+func getSessionDir() (string, error) {
+	dir := config.Get().Proxy.SessionDir
+	instance := config.Get().Proxy.SessionInstance
+	if instance != "" {
+		if instance == "." || instance == ".." || strings.ContainsAny(instance, `/\`) {
+			return "", ErrInvalidSessionInstance
+		}
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		dir = filepath.Join(dir, instance)
+	}
+	if dir == "" {
+		return os.TempDir(), nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create session directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".write-test-")
+	if err != nil {
+		return "", fmt.Errorf("session directory is not writable: %w", err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return dir, nil
+}
+
 func (a *Application) SessionName() string {
 	return a.sessionName
 }
 
+// cookieNamePrefix returns the prefix of the session cookie name for the configured
+// cookie prefix
+func cookieNamePrefix(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return "", nil
+	case "host":
+		return cookiePrefixHost, nil
+	case "secure":
+		return cookiePrefixSecure, nil
+	default:
+		return "", ErrInvalidCookiePrefix
+	}
+}
+
+// cookiePrefix returns the prefix of the session cookie name, if any
+func (a *Application) cookiePrefix() string {
+	for _, prefix := range []string{cookiePrefixHost, cookiePrefixSecure} {
+		if strings.HasPrefix(a.sessionName, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// sessionNames returns the session names of all applications of the outpost, including
+// their legacy session names, starting with the names of this application. Sessions
+// stored in files are encoded with their session name, so decoding a file requires its name.
+func (a *Application) sessionNames() []string {
+	names := append([]string{a.sessionName}, a.legacySessionNames...)
+	for _, app := range a.srv.Apps() {
+		names = append(names, app.sessionName)
+		names = append(names, app.legacySessionNames...)
+	}
+	seen := map[string]struct{}{}
+	return slices.DeleteFunc(names, func(name string) bool {
+		if _, ok := seen[name]; ok || name == "" {
+			return true
+		}
+		seen[name] = struct{}{}
+		return false
+	})
+}
+
+// decodeSessionFile decodes the values of a session file with any of the session names
+// and codecs of the outpost
+func (a *Application) decodeSessionFile(data string, values *map[interface{}]interface{}, cs []securecookie.Codec) error {
+	_, err := a.decodeSessionFileName(data, values, cs)
+	return err
+}
+
+// decodeSessionFileName decodes the values of a session file like decodeSessionFile, and
+// returns the session name it was encoded with
+func (a *Application) decodeSessionFileName(data string, values *map[interface{}]interface{}, cs []securecookie.Codec) (string, error) {
+	var err error
+	for _, name := range a.sessionNames() {
+		if err = securecookie.DecodeMulti(name, data, values, cs...); err == nil {
+			return name, nil
+		}
+	}
+	return "", err
+}
+
+// ownsSessionFile checks if the session file can be decoded by the application. Files
+// named with a legacy prefix are shared by all applications of the session directory.
+func (a *Application) ownsSessionFile(store *filesystemstore.FilesystemStore, filename string, cs []securecookie.Codec) bool {
+	data, err := store.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	values := map[interface{}]interface{}{}
+	return a.decodeSessionFile(data, &values, cs) == nil
+}
+
+// cookieSecrets returns the current cookie secret of the application, followed by
+// its configured previous cookie secrets
+func cookieSecrets(p api.ProxyOutpostConfig) []string {
+	previous := config.Get().Proxy.ForApplication(p.AssignedApplicationSlug).PreviousCookieSecrets
+	return append([]string{*p.CookieSecret}, previous...)
+}
+
+// cookieSecretCodecs returns a codec for every cookie secret of the application. Sessions
+// are encoded with the current secret, and can be decoded with any of the secrets
+func cookieSecretCodecs(maxAge int, p api.ProxyOutpostConfig) []securecookie.Codec {
+	cs := []securecookie.Codec{}
+	for _, secret := range cookieSecrets(p) {
+		cs = append(cs, codecs.CodecsFromPairs(maxAge, []byte(secret))...)
+	}
+	return cs
+}
+
+// getAllCodecs returns the codecs of all applications of the outpost. The codecs are
+// cached until the cookie secrets of the applications change.
 func (a *Application) getAllCodecs() []securecookie.Codec {
 	apps := a.srv.Apps()
+	h := sha256.New()
+	for _, app := range apps {
+		for _, secret := range cookieSecrets(app.proxyConfig) {
+			h.Write([]byte(secret))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{0})
+	}
+	key := string(h.Sum(nil))
+
+	a.codecsMutex.Lock()
+	defer a.codecsMutex.Unlock()
+	if a.codecs != nil && a.codecsKey == key {
+		return a.codecs
+	}
 	cs := []securecookie.Codec{}
 	for _, app := range apps {
-		cs = append(cs, codecs.CodecsFromPairs(0, []byte(*app.proxyConfig.CookieSecret))...)
+		cs = append(cs, cookieSecretCodecs(0, app.proxyConfig)...)
 	}
+	a.codecs = cs
+	a.codecsKey = key
 	return cs
 }
 
 func (a *Application) Logout(ctx context.Context, filter func(c Claims) bool) error {
-	if _, ok := a.sessions.(*sessions.FilesystemStore); ok {
-		files, err := os.ReadDir(os.TempDir())
-		if err != nil {
-			return err
-		}
+	_, err := a.LogoutCount(ctx, filter)
+	return err
+}
+
+// LogoutResult summarizes a logout sweep
+type LogoutResult struct {
+	Application string
+	Backend     string
+	// Number of sessions which matched the filter
+	Matched int
+	// Number of sessions which were deleted, including sessions which were tombstoned
+	// for the logout grace period
+	Deleted int
+	// Number of sessions which matched the filter but failed to be deleted
+	Failed int
+	// Number of filesystem session files which couldn't be decoded
+	Undecodable int
+	// Number of undecodable session files which were removed
+	Removed int
+	// Number of sessions which matched the filter but were kept because the
+	// pre-delete hook failed
+	Kept int
+}
+
+// OnLogout sets a function which is called with the result of every logout sweep
+func (a *Application) OnLogout(fn func(LogoutResult)) {
+	a.onLogout = fn
+}
+
+// OnBeforeDelete sets a function which is called with the claims of every session
+// before Logout deletes it, for example to revoke tokens in downstream systems. When it
+// returns an error, the session is kept and the error is logged. The function must not log
+// out sessions itself, as logouts of an application run one at a time.
+func (a *Application) OnBeforeDelete(fn func(Claims) error) {
+	a.preDelete = fn
+}
+
+// beforeDelete calls the pre-delete hook, and returns whether the session may be deleted
+func (a *Application) beforeDelete(id string, claims Claims, result *LogoutResult) bool {
+	if a.preDelete == nil {
+		return true
+	}
+	if err := a.preDelete(claims); err != nil {
+		a.log.WithError(err).WithField("id", id).Warning("pre-delete hook failed, keeping session")
+		result.Kept++
+		return false
+	}
+	return true
+}
+
+// LogoutCount deletes all sessions matching filter, and returns the number of sessions
+// which were deleted. Sessions which fail to decode or to be deleted are not counted.
+func (a *Application) LogoutCount(ctx context.Context, filter func(c Claims) bool) (int, error) {
+	return a.logoutCount(ctx, sessionScope{}, fullSweep(filter))
+}
+
+// LogoutUntil deletes the sessions matching filter like LogoutCount, but stops checking
+// further sessions once filter signals to stop, for example after the single session it
+// looks for was found
+func (a *Application) LogoutUntil(ctx context.Context, filter LogoutFilter) (int, error) {
+	return a.logoutCount(ctx, sessionScope{}, filter)
+}
+
+// LogoutTenant deletes all sessions of tenant matching filter, and returns the number of
+// sessions which were deleted. Only the sessions created while a SessionTenantDomain is
+// configured belong to a tenant, see tenantFromHost.
+func (a *Application) LogoutTenant(ctx context.Context, tenant string, filter func(c Claims) bool) (int, error) {
+	if tenant == "" {
+		return 0, errors.New("no tenant given")
+	}
+	return a.logoutCount(ctx, sessionScope{tenant: tenant}, fullSweep(filter))
+}
+
+// LogoutUser deletes all sessions of the user with the given subject, and returns the
+// number of sessions which were deleted
+func (a *Application) LogoutUser(ctx context.Context, sub string) (int, error) {
+	return a.logoutCount(ctx, a.tagScope(sessionTagSubject, sub), fullSweep(func(c Claims) bool {
+		return c.Sub == sub
+	}))
+}
+
+// LogoutSID deletes all sessions with the given authentik session ID, and returns the
+// number of sessions which were deleted
+func (a *Application) LogoutSID(ctx context.Context, sid string) (int, error) {
+	return a.logoutCount(ctx, a.tagScope(sessionTagSID, sid), fullSweep(func(c Claims) bool {
+		return c.Sid == sid
+	}))
+}
+
+func (a *Application) logoutCount(ctx context.Context, scope sessionScope, filter LogoutFilter) (int, error) {
+	release, err := a.logoutGuard.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	result := LogoutResult{
+		Application: a.proxyConfig.AssignedApplicationSlug,
+		Backend:     a.sessionBackend(),
+	}
+	err = a.logout(ctx, scope, filter, &result, nil)
+	a.reportLogout(result)
+	return result.Deleted, err
+}
+
+// reportLogout logs the result of a logout sweep and passes it to the OnLogout function
+func (a *Application) reportLogout(result LogoutResult) {
+	a.log.WithFields(log.Fields{
+		"application": result.Application,
+		"backend":     result.Backend,
+		"matched":     result.Matched,
+		"deleted":     result.Deleted,
+		"failed":      result.Failed,
+		"undecodable": result.Undecodable,
+		"removed":     result.Removed,
+		"kept":        result.Kept,
+	}).Info("logged out sessions")
+	if a.onLogout != nil {
+		a.onLogout(result)
+	}
+}
+
+// LogoutAll deletes all sessions of the application without decoding them, and returns
+// the number of deleted sessions. As the claims of the sessions aren't read, the
+// pre-delete hook isn't called, and sessions are deleted right away regardless of the
+// logout grace period. Redis sessions are deleted by key prefix, so ErrSharedKeyPrefix is
+// returned unless the prefix belongs to the application, see redisKeyPrefixShared.
+func (a *Application) LogoutAll(ctx context.Context) (int, error) {
+	if _, ok := a.sessionStore().(*redisstore.RedisStore); ok && a.redisKeyPrefixShared() {
+		return 0, ErrSharedKeyPrefix
+	}
+	release, err := a.logoutGuard.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	result := LogoutResult{
+		Application: a.proxyConfig.AssignedApplicationSlug,
+		Backend:     a.sessionBackend(),
+	}
+	switch store := a.sessionStore().(type) {
+	case *redisstore.RedisStore:
+		err = store.Scan(ctx, func(keys []string) error {
+			deleted, failed := a.deleteRedisSessions(ctx, store, keys)
+			result.Deleted += deleted
+			result.Failed += failed
+			return nil
+		})
+	case *filesystemstore.FilesystemStore:
+		sessionSweepMutex.Lock()
+		defer sessionSweepMutex.Unlock()
+		var files []os.DirEntry
+		files, err = os.ReadDir(store.Path())
+		cs := a.getAllCodecs()
 		for _, file := range files {
-			s := sessions.Session{}
-			if !strings.HasPrefix(file.Name(), "session_") {
+			legacy := store.IsLegacySessionFile(file.Name())
+			if !legacy && !store.IsSessionFile(file.Name()) {
 				continue
 			}
-			fullPath := path.Join(os.TempDir(), file.Name())
-			data, err := os.ReadFile(fullPath)
-			if err != nil {
-				a.log.WithError(err).Warning("failed to read file")
+			fullPath := path.Join(store.Path(), file.Name())
+			if legacy && !a.ownsSessionFile(store, fullPath, cs) {
 				continue
 			}
-			err = securecookie.DecodeMulti(
-				a.SessionName(), string(data),
-				&s.Values, a.getAllCodecs()...,
-			)
-			if err != nil {
-				a.log.WithError(err).Trace("failed to decode session")
+			if err := store.RemoveFile(fullPath); err != nil {
+				if !os.IsNotExist(err) {
+					a.log.WithError(err).WithField("id", fullPath).Warning("failed to delete session")
+					result.Failed++
+				}
 				continue
 			}
-			rc, ok := s.Values[constants.SessionClaims]
-			if !ok || rc == nil {
-				continue
+			result.Deleted++
+		}
+	case *memorystore.MemoryStore:
+		store.Range(func(id string, values map[interface{}]interface{}) bool {
+			store.Delete(id)
+			result.Deleted++
+			return true
+		})
+	case LogoutStore:
+		err = store.Walk(ctx, func(id string, values map[interface{}]interface{}) bool {
+			if err := store.Delete(ctx, id); err != nil {
+				a.log.WithError(err).WithField("id", id).Warning("failed to delete session")
+				result.Failed++
+				return true
 			}
-			claims := s.Values[constants.SessionClaims].(Claims)
-			if filter(claims) {
-				a.log.WithField("path", fullPath).Trace("deleting session")
-				err := os.Remove(fullPath)
-				if err != nil {
-					a.log.WithError(err).Warning("failed to delete session")
-					continue
-				}
+			result.Deleted++
+			return true
+		})
+	}
+	result.Matched = result.Deleted + result.Failed
+	a.reportLogout(result)
+	return result.Deleted, err
+}
+
+// LogoutDryRun returns the claims of all sessions which Logout would delete with the
+// same filter, without deleting them
+func (a *Application) LogoutDryRun(ctx context.Context, filter func(c Claims) bool) ([]Claims, error) {
+	claims := []Claims{}
+	result := LogoutResult{}
+	err := a.logout(ctx, sessionScope{}, fullSweep(filter), &result, func(c Claims) {
+		claims = append(claims, c)
+	})
+	return claims, err
+}
+
+// CountSessions returns the number of sessions which Logout would delete with the same
+// filter, without deleting them
+func (a *Application) CountSessions(ctx context.Context, filter func(c Claims) bool) (int, error) {
+	result := LogoutResult{}
+	err := a.logout(ctx, sessionScope{}, fullSweep(filter), &result, func(c Claims) {})
+	return result.Matched, err
+}
+
+// LogoutFilter returns whether the session with claims c matches, and whether the sweep
+// can stop after this session because no further sessions have to be checked
+type LogoutFilter func(c Claims) (match bool, stop bool)
+
+// fullSweep returns a LogoutFilter which checks every session with filter
+func fullSweep(filter func(c Claims) bool) LogoutFilter {
+	return func(c Claims) (bool, bool) {
+		return filter(c), false
+	}
+}
+
+// logout deletes all sessions matching filter and records the outcome in result, limited
+// to the sessions in scope, until filter stops the sweep. If dryRun is set, it is called
+// with the claims of every matching session instead, and no sessions or undecodable files
+// are removed. With a logout grace period, matching sessions are tombstoned instead of
+// deleted, see tombstoneSession.
+func (a *Application) logout(ctx context.Context, scope sessionScope, filter LogoutFilter, result *LogoutResult, dryRun func(c Claims)) error {
+	grace := a.logoutGracePeriod()
+	if rs, ok := a.sessionStore().(*redisstore.RedisStore); ok {
+		// Matching keys are deleted in batches while scanning, so that memory use doesn't
+		// grow with the size of the keyspace
+		keys := make([]string, 0, redisDeleteBatchSize)
+		flush := func() {
+			deleted, failed := a.deleteRedisSessions(ctx, rs, keys)
+			result.Deleted += deleted
+			result.Failed += failed
+			keys = keys[:0]
+		}
+		// SCAN may return the same key more than once. Keys which were deleted already
+		// are skipped as they can't be read anymore, so only keys of the current batch
+		// and, during dry runs, all keys have to be remembered
+		seen := map[string]struct{}{}
+		err := a.walkScopedSessions(ctx, scope, func(id string, claims Claims) bool {
+			if _, ok := seen[id]; ok {
+				return true
+			}
+			match, stop := filter(claims)
+			if !match {
+				return !stop
+			}
+			seen[id] = struct{}{}
+			result.Matched++
+			if dryRun != nil {
+				dryRun(claims)
+				return !stop
+			}
+			if !a.beforeDelete(id, claims, result) {
+				return !stop
+			}
+			if grace > 0 {
+				a.tombstoneMatchedSession(ctx, id, grace, result)
+				return !stop
 			}
+			keys = append(keys, id)
+			if len(keys) >= redisDeleteBatchSize {
+				flush()
+				clear(seen)
+			}
+			return !stop
+		}, nil)
+		if dryRun == nil && len(keys) > 0 {
+			flush()
+		}
+		return err
+	}
+	fs, _ := a.sessionStore().(*filesystemstore.FilesystemStore)
+	if fs != nil {
+		sessionSweepMutex.Lock()
+		defer sessionSweepMutex.Unlock()
+	}
+	cleanupAfter := config.Get().Proxy.SessionCleanupUndecodableAfter
+	return a.walkScopedSessions(ctx, scope, func(id string, claims Claims) bool {
+		match, stop := filter(claims)
+		if !match {
+			return !stop
+		}
+		result.Matched++
+		if dryRun != nil {
+			dryRun(claims)
+			return !stop
+		}
+		if !a.beforeDelete(id, claims, result) {
+			return !stop
+		}
+		if grace > 0 {
+			a.tombstoneMatchedSession(ctx, id, grace, result)
+			return !stop
+		}
+		a.log.WithField("id", id).Trace("deleting session")
+		ok, err := a.deleteSession(id)
+		if err != nil {
+			a.log.WithError(err).WithField("id", id).Warning("failed to delete session")
+			result.Failed++
+			return !stop
+		}
+		if ok {
+			result.Deleted++
+		}
+		return !stop
+	}, func(id string, modTime time.Time) {
+		result.Undecodable++
+		if dryRun != nil || cleanupAfter <= 0 || time.Since(modTime) < cleanupAfter {
+			return
+		}
+		// Only filesystem sessions can be undecodable
+		if err := fs.RemoveFile(id); err != nil && !os.IsNotExist(err) {
+			a.log.WithError(err).WithField("id", id).Warning("failed to remove undecodable session")
+			return
 		}
+		result.Removed++
+	})
+}
+
+// Healthy checks whether the session backend of this application is reachable, by
+// pinging Redis or checking the session directory
+func (a *Application) Healthy(ctx context.Context) error {
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		info, err := os.Stat(store.Path())
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("session directory %s is not a directory", store.Path())
+		}
+	case *redisstore.RedisStore:
+		ctx, cancel := context.WithTimeout(ctx, redisTimeout())
+		defer cancel()
+		return store.Client().Ping(ctx).Err()
+	case *postgresstore.PostgresStore:
+		ctx, cancel := context.WithTimeout(ctx, defaultPostgresTimeout)
+		defer cancel()
+		return store.Pool().Ping(ctx)
 	}
-	if rs, ok := a.sessions.(*redisstore.RedisStore); ok {
-		client := rs.Client()
-		keys, err := client.Keys(ctx, fmt.Sprintf("%s*", RedisKeyPrefix)).Result()
+	return nil
+}
+
+// LogoutSession deletes the session with the given ID without scanning the store, regardless
+// of the logout grace period. Deleting a session which doesn't exist is not an error.
+func (a *Application) LogoutSession(ctx context.Context, sessionID string) error {
+	a.log.WithField("id", sessionID).Trace("deleting session")
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		return store.Delete(sessionID)
+	case *memorystore.MemoryStore:
+		store.Delete(sessionID)
+	case *redisstore.RedisStore:
+		ctx, cancel := context.WithTimeout(ctx, redisTimeout())
+		defer cancel()
+		return store.Delete(ctx, sessionID)
+	case LogoutStore:
+		return store.Delete(ctx, sessionID)
+	}
+	return nil
+}
+
+// Sessions returns the claims of all active sessions of this application, oldest first
+// Sessions whose claims expired are skipped, allowing for the configured clock skew
+func (a *Application) Sessions(ctx context.Context) ([]Claims, error) {
+	claims := []Claims{}
+	// SCAN may return the same key more than once
+	seen := map[string]struct{}{}
+	err := a.walkSessions(ctx, func(id string, c Claims) bool {
+		if _, ok := seen[id]; ok || claimsExpired(c) {
+			return true
+		}
+		seen[id] = struct{}{}
+		claims = append(claims, c)
+		return true
+	}, nil)
+	sort.SliceStable(claims, func(i, j int) bool {
+		return claims[i].CreatedAt < claims[j].CreatedAt
+	})
+	return claims, err
+}
+
+// SessionsByUser returns the number of active sessions of this application by the subject
+// of their user. Sessions are read in a single pass like Sessions, without blocking logouts
+// or the session cleanup, and sessions which can't be decoded are skipped.
+func (a *Application) SessionsByUser(ctx context.Context) (map[string]int, error) {
+	counts := map[string]int{}
+	// SCAN may return the same key more than once
+	seen := map[string]struct{}{}
+	err := a.walkSessions(ctx, func(id string, c Claims) bool {
+		if _, ok := seen[id]; ok || claimsExpired(c) {
+			return true
+		}
+		seen[id] = struct{}{}
+		counts[c.Sub]++
+		return true
+	}, nil)
+	return counts, err
+}
+
+// walkSessions calls fn with the claims of every session in the store, identified
+// by the file path for filesystem sessions, the key for redis sessions, and the ID
+// passed by custom stores implementing LogoutStore, until fn
+// returns false. Sessions which can't be read or decoded, which have no claims or which
+// were logged out and are kept for their grace period are skipped.
+// If undecodable is set, it is called with the path and modification time of every
+// filesystem session file which was read but couldn't be decoded, for example
+// because it was encoded with a cookie secret which is no longer configured.
+func (a *Application) walkSessions(
+	ctx context.Context,
+	fn func(id string, claims Claims) bool,
+	undecodable func(id string, modTime time.Time),
+) error {
+	return a.walkScopedSessions(ctx, sessionScope{}, fn, undecodable)
+}
+
+// walkScopedSessions walks the sessions like walkSessions, limited to the sessions in
+// scope. Redis only scans the keys of the tenant, and filesystem session files whose
+// tags don't match are skipped without reading them.
+func (a *Application) walkScopedSessions(
+	ctx context.Context,
+	scope sessionScope,
+	fn func(id string, claims Claims) bool,
+	undecodable func(id string, modTime time.Time),
+) error {
+	scoped := func(id string) bool {
+		return a.inScope(id, scope)
+	}
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		files, err := os.ReadDir(store.Path())
 		if err != nil {
 			return err
 		}
-		serializer := redisstore.GobSerializer{}
-		for _, key := range keys {
-			v, err := client.Get(ctx, key).Result()
+		cs := a.getAllCodecs()
+		for _, file := range files {
+			legacy := store.IsLegacySessionFile(file.Name())
+			if !legacy && !store.IsSessionFile(file.Name()) {
+				continue
+			}
+			fullPath := path.Join(store.Path(), file.Name())
+			if !scoped(fullPath) {
+				continue
+			}
+			data, err := store.ReadFile(fullPath)
 			if err != nil {
-				a.log.WithError(err).Warning("failed to get value")
+				a.log.WithError(err).Warning("failed to read file")
 				continue
 			}
 			s := sessions.Session{}
-			err = serializer.Deserialize([]byte(v), &s)
+			err = a.decodeSessionFile(data, &s.Values, cs)
+			if err != nil && legacy {
+				// Files with a legacy name may belong to other applications
+				continue
+			}
 			if err != nil {
-				a.log.WithError(err).Warning("failed to deserialize")
+				a.countDecodeError()
+				a.log.WithError(err).WithField("id", fullPath).Debug("failed to decode session")
+				if undecodable == nil {
+					continue
+				}
+				info, err := file.Info()
+				if err != nil {
+					continue
+				}
+				undecodable(fullPath, info.ModTime())
 				continue
 			}
-			c := s.Values[constants.SessionClaims]
-			if c == nil {
+			claims, ok := liveSessionClaims(s.Values)
+			if !ok {
+				a.log.WithField("id", fullPath).Trace("session has no claims or was logged out")
 				continue
 			}
-			claims := c.(Claims)
-			if filter(claims) {
-				a.log.WithField("key", key).Trace("deleting session")
-				_, err := client.Del(ctx, key).Result()
+			if !fn(fullPath, claims) {
+				return nil
+			}
+		}
+	case *memorystore.MemoryStore:
+		store.Range(func(id string, values map[interface{}]interface{}) bool {
+			if claims, ok := liveSessionClaims(values); ok && scoped(id) {
+				return fn(id, claims)
+			}
+			return true
+		})
+	case *redisstore.RedisStore:
+		client := store.Client()
+		serializer := a.redisSerializer(client)
+		keyPrefix := a.redisKeyPrefix()
+		idPrefix := ""
+		if scope.tenant != "" {
+			idPrefix = tenantIDPrefix(scope.tenant)
+		}
+		err := store.ScanPrefix(ctx, idPrefix, func(keys []string) error {
+			for _, key := range keys {
+				if !scoped(key) {
+					continue
+				}
+				var v string
+				err := withRedisRetry(ctx, func(ctx context.Context) error {
+					var err error
+					v, err = client.Get(ctx, key).Result()
+					return err
+				})
+				if errors.Is(err, redis.Nil) {
+					// The session expired or was deleted since it was scanned
+					continue
+				}
 				if err != nil {
-					a.log.WithError(err).Warning("failed to delete key")
+					a.log.WithError(err).WithField("key", key).Warning("failed to get value")
 					continue
 				}
+				// Serializers are passed sessions with their storage ID, so that claims
+				// stored outside of the session are restored
+				s := sessions.Session{
+					ID:     strings.TrimPrefix(key, keyPrefix),
+					Values: map[interface{}]interface{}{},
+				}
+				err = serializer.Deserialize([]byte(v), &s)
+				if err != nil {
+					a.countDecodeError()
+					a.log.WithError(err).Warning("failed to deserialize")
+					continue
+				}
+				if claims, ok := liveSessionClaims(s.Values); ok && !fn(key, claims) {
+					return errStopWalk
+				}
 			}
+			return nil
+		})
+		if errors.Is(err, errStopWalk) {
+			return nil
 		}
+		return err
+	case LogoutStore:
+		return store.Walk(ctx, func(id string, values map[interface{}]interface{}) bool {
+			if claims, ok := liveSessionClaims(values); ok && scoped(id) {
+				return fn(id, claims)
+			}
+			return true
+		})
 	}
 	return nil
 }
+
+// errStopWalk ends a scan of Redis sessions when the walk function returns false
+var errStopWalk = errors.New("stop walking sessions")
+
+// deleteRedisSessions deletes the given keys with pipelines of up to redisDeleteBatchSize
+// keys each, and returns the number of deleted sessions and of keys which failed to be
+// deleted. Keys which fail to be deleted are retried with backoff, and logged and skipped
+// once the retries are exhausted.
+func (a *Application) deleteRedisSessions(ctx context.Context, rs *redisstore.RedisStore, keys []string) (int, int) {
+	deleted, failed := 0, 0
+	for start := 0; start < len(keys); start += redisDeleteBatchSize {
+		pending := keys[start:min(start+redisDeleteBatchSize, len(keys))]
+		for attempt := 0; ; attempt++ {
+			d, errs := a.deleteRedisBatch(ctx, rs, pending)
+			deleted += d
+			pending = slices.Collect(maps.Keys(errs))
+			if len(pending) == 0 {
+				break
+			}
+			if attempt >= redisRetries || !redisBackoff(ctx, attempt) {
+				for key, err := range errs {
+					a.log.WithError(err).WithField("key", key).Warning("failed to delete key")
+				}
+				failed += len(pending)
+				break
+			}
+		}
+	}
+	return deleted, failed
+}
+
+// deleteRedisBatch deletes the given keys with a single pipeline, and returns the number
+// of deleted keys and the error of every key which failed to be deleted
+func (a *Application) deleteRedisBatch(ctx context.Context, rs *redisstore.RedisStore, batch []string) (int, map[string]error) {
+	delCtx, cancel := context.WithTimeout(ctx, redisTimeout())
+	defer cancel()
+	pipe := rs.Client().Pipeline()
+	cmds := make([]*redis.IntCmd, len(batch))
+	for i, key := range batch {
+		a.log.WithField("key", key).Trace("deleting session")
+		cmds[i] = pipe.Del(delCtx, key)
+		// Linked keys expire along with the session if they fail to be deleted
+		for _, linked := range rs.LinkedKeysOf(key) {
+			pipe.Del(delCtx, linked)
+		}
+	}
+	// Errors are checked for every command below
+	_, _ = pipe.Exec(delCtx)
+	deleted := 0
+	errs := map[string]error{}
+	for i, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			errs[batch[i]] = err
+			continue
+		}
+		deleted += int(cmd.Val())
+	}
+	return deleted, errs
+}
+
+// deleteSession deletes the filesystem, memory or custom session with the given ID, as
+// passed by walkSessions, and returns whether a session was deleted. Redis sessions are
+// deleted in batches by deleteRedisSessions
+func (a *Application) deleteSession(id string) (bool, error) {
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		if err := store.RemoveFile(id); err != nil {
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	case *memorystore.MemoryStore:
+		store.Delete(id)
+		return true, nil
+	case LogoutStore:
+		if err := store.Delete(context.Background(), id); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
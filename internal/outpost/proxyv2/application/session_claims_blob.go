@@ -0,0 +1,124 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+const (
+	// claimsBlobKeyPrefix is the prefix of the keys claims stored outside of their session
+	// are stored under, followed by the key of the session. It doesn't share a prefix with
+	// session keys, so claims aren't scanned as sessions.
+	claimsBlobKeyPrefix = "authentik_proxy_claims:"
+	// sessionClaimsBlob marks sessions whose full claims are stored outside of the session
+	sessionClaimsBlob = "ak_claims_blob"
+	// claimsBlobTTLMargin keeps stored claims for a bit longer than their session, so that
+	// the session never outlives its claims
+	claimsBlobTTLMargin = time.Minute
+)
+
+// errClaimsBlobMissing is returned when the claims of a session can't be found
+var errClaimsBlobMissing = errors.New("claims of session not found")
+
+func claimsBlobKey(sessionKey string) string {
+	return claimsBlobKeyPrefix + sessionKey
+}
+
+// slimClaims returns the claims which are kept in a session whose full claims are stored
+// outside of it, which are the claims used to filter and limit sessions
+func slimClaims(c Claims) Claims {
+	return Claims{
+		Sub:                 c.Sub,
+		Exp:                 c.Exp,
+		Email:               c.Email,
+		Name:                c.Name,
+		PreferredUsername:   c.PreferredUsername,
+		Sid:                 c.Sid,
+		CreatedAt:           c.CreatedAt,
+		ExpiresAt:           c.ExpiresAt,
+		ImpersonatorSubject: c.ImpersonatorSubject,
+	}
+}
+
+// claimsBlobSerializer stores the claims of sessions in a separate Redis key when they
+// exceed the configured size, such as ID tokens with many groups, and keeps the slim
+// claims in the session. Loading a session transparently restores the full claims.
+type claimsBlobSerializer struct {
+	redisstore.SessionSerializer
+	client    redis.UniversalClient
+	keyPrefix string
+}
+
+func (cs claimsBlobSerializer) Serialize(s *sessions.Session) ([]byte, error) {
+	threshold := config.Get().Proxy.SessionClaimsBlobThreshold
+	var claims Claims
+	switch c := s.Values[constants.SessionClaims].(type) {
+	case Claims:
+		claims = c
+	case *Claims:
+		claims = *c
+	default:
+		return cs.SessionSerializer.Serialize(s)
+	}
+	// Sessions without a max age don't expire, while the stored claims would
+	if threshold <= 0 || s.ID == "" || s.Options == nil || s.Options.MaxAge <= 0 {
+		return cs.SessionSerializer.Serialize(s)
+	}
+	blob, err := cs.SessionSerializer.Serialize(&sessions.Session{
+		Values: map[interface{}]interface{}{constants.SessionClaims: claims},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) <= threshold {
+		return cs.SessionSerializer.Serialize(s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout())
+	defer cancel()
+	ttl := time.Duration(s.Options.MaxAge)*time.Second + claimsBlobTTLMargin
+	if err := cs.client.Set(ctx, claimsBlobKey(cs.keyPrefix+s.ID), blob, ttl).Err(); err != nil {
+		return nil, err
+	}
+	slim := &sessions.Session{
+		ID:      s.ID,
+		Values:  make(map[interface{}]interface{}, len(s.Values)+1),
+		Options: s.Options,
+	}
+	for k, v := range s.Values {
+		slim.Values[k] = v
+	}
+	slim.Values[constants.SessionClaims] = slimClaims(claims)
+	slim.Values[sessionClaimsBlob] = true
+	return cs.SessionSerializer.Serialize(slim)
+}
+
+func (cs claimsBlobSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	if err := cs.SessionSerializer.Deserialize(d, s); err != nil {
+		return err
+	}
+	if external, _ := s.Values[sessionClaimsBlob].(bool); !external {
+		return nil
+	}
+	delete(s.Values, sessionClaimsBlob)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout())
+	defer cancel()
+	blob, err := cs.client.Get(ctx, claimsBlobKey(cs.keyPrefix+s.ID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return errClaimsBlobMissing
+	} else if err != nil {
+		return err
+	}
+	full := &sessions.Session{Values: map[interface{}]interface{}{}}
+	if err := cs.SessionSerializer.Deserialize(blob, full); err != nil {
+		return err
+	}
+	s.Values[constants.SessionClaims] = full.Values[constants.SessionClaims]
+	return nil
+}
@@ -0,0 +1,86 @@
+package application
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+func TestClaimsBlobSerializer_Inline(t *testing.T) {
+	config.Get().Proxy.SessionClaimsBlobThreshold = 1 << 20
+	defer func() {
+		config.Get().Proxy.SessionClaimsBlobThreshold = 0
+	}()
+	// Claims below the threshold are kept in the session without accessing Redis
+	cs := claimsBlobSerializer{SessionSerializer: redisstore.JSONSerializer{}}
+	claims := Claims{Sub: "foo", Groups: []string{"bar", "baz"}}
+	s := &sessions.Session{
+		ID:      "id",
+		Values:  map[interface{}]interface{}{constants.SessionClaims: &claims},
+		Options: &sessions.Options{MaxAge: 60},
+	}
+	data, err := cs.Serialize(s)
+	assert.NoError(t, err)
+
+	loaded := &sessions.Session{ID: "id", Values: map[interface{}]interface{}{}}
+	assert.NoError(t, cs.Deserialize(data, loaded))
+	assert.Equal(t, claims, loaded.Values[constants.SessionClaims])
+	assert.NotContains(t, loaded.Values, sessionClaimsBlob)
+}
+
+func TestSlimClaims(t *testing.T) {
+	c := Claims{
+		Sub:       "foo",
+		Sid:       "bar",
+		Exp:       1,
+		CreatedAt: 2,
+		Groups:    []string{"baz"},
+		RawToken:  "token",
+		Proxy:     &ProxyClaims{IsSuperuser: true},
+	}
+	slim := slimClaims(c)
+	assert.Equal(t, Claims{Sub: "foo", Sid: "bar", Exp: 1, CreatedAt: 2}, slim)
+	assert.Equal(t, "authentik_proxy_claims:authentik_proxy_session_id", claimsBlobKey(RedisKeyPrefix+"id"))
+}
+
+func TestLogout_ClaimsBlobGroup(t *testing.T) {
+	config.Get().Proxy.SessionClaimsBlobThreshold = 1
+	// Don't touch the sessions of anything else using the Redis instance
+	config.Get().Proxy.SessionKeyPrefix = "authentik_proxy_test_" + uuid.NewString() + "_"
+	defer func() {
+		config.Get().Proxy.SessionClaimsBlobThreshold = 0
+		config.Get().Proxy.SessionKeyPrefix = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	useTestRedisStore(t, a)
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo", Groups: []string{"admins"}}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	claims := func() (Claims, bool) {
+		r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		r.AddCookie(rr.Result().Cookies()[0])
+		return a.ClaimsFromRequest(r)
+	}
+	c, ok := claims()
+	assert.True(t, ok)
+	assert.Equal(t, []string{"admins"}, c.Groups)
+
+	// The groups are only stored with the full claims outside of the session
+	deleted, err := a.LogoutCount(context.Background(), InGroup("admins"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, ok = claims()
+	assert.False(t, ok)
+}
@@ -0,0 +1,150 @@
+package application
+
+import (
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+)
+
+// defaultSessionCleanupInterval is how often expired filesystem sessions are removed
+const defaultSessionCleanupInterval = 1 * time.Hour
+
+// sessionSweepMutex is held while session files are removed by logout or cleanup
+// sweeps, which may run concurrently for applications sharing a session directory
+var sessionSweepMutex sync.Mutex
+
+func sessionCleanupInterval() time.Duration {
+	if i := config.Get().Proxy.SessionCleanupInterval; i != 0 {
+		return i
+	}
+	return defaultSessionCleanupInterval
+}
+
+func (a *Application) runSessionCleanup() {
+	interval := sessionCleanupInterval()
+	if interval < 0 {
+		return
+	}
+	switch a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore, *postgresstore.PostgresStore:
+	default:
+		// Redis and memory sessions expire on their own
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.cleanupSessions()
+		}
+	}
+}
+
+// cleanupSessions removes the session files of this application which have expired,
+// and returns the number of removed files. A session expires once its max age has passed
+// since it was last saved, when its claims or its deadline expire, or when the grace period of a session
+// which was logged out ends. Sessions of other applications
+// sharing the session directory can't be decoded and are left alone, which includes the
+// files named without the slug of their application before.
+func (a *Application) cleanupSessions() int {
+	if ps, ok := a.sessionStore().(*postgresstore.PostgresStore); ok {
+		return a.cleanupPostgresSessions(ps)
+	}
+	store, ok := a.sessionStore().(*filesystemstore.FilesystemStore)
+	if !ok {
+		return 0
+	}
+	sessionSweepMutex.Lock()
+	defer sessionSweepMutex.Unlock()
+	files, err := os.ReadDir(store.Path())
+	if err != nil {
+		a.log.WithError(err).Warning("failed to read session directory")
+		return 0
+	}
+	// Decode without checking the timestamp, so that expired sessions can be identified
+	cs := cookieSecretCodecs(0, a.proxyConfig)
+	maxAge := time.Duration(store.Options.MaxAge) * time.Second
+	removed := 0
+	for _, file := range files {
+		legacy := store.IsLegacySessionFile(file.Name())
+		if !legacy && !store.IsSessionFile(file.Name()) {
+			continue
+		}
+		fullPath := path.Join(store.Path(), file.Name())
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		data, err := store.ReadFile(fullPath)
+		if err != nil {
+			continue
+		}
+		s := sessions.Session{}
+		if err := a.decodeSessionFile(data, &s.Values, cs); err != nil {
+			// Files with a legacy name may belong to other applications
+			if !legacy {
+				a.countDecodeError()
+			}
+			continue
+		}
+		if !sessionExpired(sessionFileExpiry(info.ModTime(), maxAge, s.Values)) {
+			continue
+		}
+		if err := store.RemoveFile(fullPath); err != nil {
+			if !os.IsNotExist(err) {
+				a.log.WithError(err).WithField("id", fullPath).Warning("failed to remove expired session")
+			}
+			continue
+		}
+		removed++
+	}
+	a.log.WithField("removed", removed).Debug("removed expired sessions")
+	return removed
+}
+
+// sessionExpired returns whether a session which expires at expires has expired, allowing
+// for the configured clock skew. A zero expires never expires.
+func sessionExpired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires.Add(max(config.Get().Proxy.SessionClockSkew, 0)))
+}
+
+// claimsExpired returns whether the claims c or the deadline of their session have
+// expired, allowing for the configured clock skew. Claims without an expiry never expire.
+func claimsExpired(c Claims) bool {
+	return (c.Exp != 0 && sessionExpired(time.Unix(int64(c.Exp), 0))) || deadlinePassed(c)
+}
+
+// deadlinePassed returns whether the absolute deadline of the session with claims c has
+// passed, allowing for the configured clock skew. Sessions without a deadline never pass it.
+func deadlinePassed(c Claims) bool {
+	return c.ExpiresAt != 0 && sessionExpired(time.Unix(c.ExpiresAt, 0))
+}
+
+// sessionFileExpiry returns when the session file last modified at modTime with the given
+// values expires, which is zero when it doesn't expire
+func sessionFileExpiry(modTime time.Time, maxAge time.Duration, values map[interface{}]interface{}) time.Time {
+	var expires time.Time
+	if maxAge > 0 {
+		expires = modTime.Add(maxAge)
+	}
+	if claims, ok := sessionClaims(values); ok {
+		for _, exp := range []int64{int64(claims.Exp), claims.ExpiresAt} {
+			if exp != 0 && (expires.IsZero() || time.Unix(exp, 0).Before(expires)) {
+				expires = time.Unix(exp, 0)
+			}
+		}
+	}
+	if until := tombstoneExpiry(values); !until.IsZero() && (expires.IsZero() || until.Before(expires)) {
+		expires = until
+	}
+	return expires
+}
@@ -0,0 +1,156 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+func TestCleanupSessions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	config.Get().Proxy.SessionDir = dir
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	store.Options.MaxAge = 3600
+
+	save := func(claims Claims) string {
+		req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = claims
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+		return filepath.Join(dir, filesystemstore.SessionFilePrefix+s.ID)
+	}
+	active := save(Claims{Sub: "active", Exp: int(time.Now().Add(time.Hour).Unix())})
+	expiredClaims := save(Claims{Sub: "expired", Exp: int(time.Now().Add(-time.Hour).Unix())})
+	stale := save(Claims{Sub: "stale"})
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(stale, old, old))
+
+	assert.Equal(t, 2, a.cleanupSessions())
+	assert.FileExists(t, active)
+	assert.NoFileExists(t, expiredClaims)
+	assert.NoFileExists(t, stale)
+}
+
+func TestCleanupSessions_LegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	config.Get().Proxy.SessionDir = dir
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t, withSlug("foo"))
+	defer a.Stop()
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	store.Options.MaxAge = 3600
+
+	// Sessions were saved without the slug in their file name before
+	save := func(claims Claims) (*http.Cookie, string) {
+		req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = claims
+		rr := httptest.NewRecorder()
+		assert.NoError(t, a.sessions.Save(req, rr, s))
+		filename := store.Filename(s.ID)
+		legacy := filepath.Join(dir, filesystemstore.SessionFilePrefix+strings.TrimPrefix(filepath.Base(filename), filesystemstore.SessionFilePrefix+"foo."))
+		assert.NoError(t, os.Rename(filename, legacy))
+		return rr.Result().Cookies()[0], legacy
+	}
+	cookie, active := save(Claims{Sub: "active", Exp: int(time.Now().Add(time.Hour).Unix())})
+	_, expired := save(Claims{Sub: "expired", Exp: int(time.Now().Add(-time.Hour).Unix())})
+	// Files of other applications can't be decoded and are left alone
+	foreign := filepath.Join(dir, filesystemstore.SessionFilePrefix+"foreign")
+	assert.NoError(t, os.WriteFile(foreign, []byte("undecodable"), 0600))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(foreign, old, old))
+
+	assert.Equal(t, 1, a.cleanupSessions())
+	assert.FileExists(t, active)
+	assert.NoFileExists(t, expired)
+	assert.FileExists(t, foreign)
+
+	// Legacy files are read and renamed when the session is loaded
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.AddCookie(cookie)
+	c, ok := a.ClaimsFromRequest(req)
+	assert.True(t, ok)
+	assert.Equal(t, "active", c.Sub)
+	assert.NoFileExists(t, active)
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestLogout_LegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	config.Get().Proxy.SessionDir = dir
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t, withSlug("foo"))
+	defer a.Stop()
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+
+	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo", Exp: int(time.Now().Add(time.Hour).Unix())}
+	assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	legacy := filepath.Join(dir, filesystemstore.SessionFilePrefix+"legacy")
+	assert.NoError(t, os.Rename(store.Filename(s.ID), legacy))
+	foreign := filepath.Join(dir, filesystemstore.SessionFilePrefix+"foreign")
+	assert.NoError(t, os.WriteFile(foreign, []byte("undecodable"), 0600))
+
+	n, err := a.LogoutCount(context.Background(), func(c Claims) bool { return c.Sub == "foo" })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoFileExists(t, legacy)
+	assert.FileExists(t, foreign)
+
+	assert.NoError(t, os.WriteFile(legacy, []byte("undecodable"), 0600))
+	n, err = a.LogoutAll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.FileExists(t, legacy)
+}
+
+func TestSessionFileExpiry(t *testing.T) {
+	modTime := time.Now()
+	assert.True(t, sessionFileExpiry(modTime, 0, map[interface{}]interface{}{}).IsZero())
+	assert.Equal(t, modTime.Add(time.Hour), sessionFileExpiry(modTime, time.Hour, map[interface{}]interface{}{}))
+	// Claims expiring before the max age end the session
+	exp := modTime.Add(time.Minute).Truncate(time.Second)
+	values := map[interface{}]interface{}{constants.SessionClaims: Claims{Exp: int(exp.Unix())}}
+	assert.Equal(t, exp, sessionFileExpiry(modTime, time.Hour, values))
+}
+
+func TestSessionExpired_ClockSkew(t *testing.T) {
+	defer func() {
+		config.Get().Proxy.SessionClockSkew = 0
+	}()
+	assert.False(t, sessionExpired(time.Time{}))
+	assert.True(t, sessionExpired(time.Now().Add(-time.Second)))
+	assert.False(t, claimsExpired(Claims{}))
+
+	// Sessions which expired within the tolerance are still valid
+	config.Get().Proxy.SessionClockSkew = time.Minute
+	assert.False(t, sessionExpired(time.Now().Add(-30*time.Second)))
+	assert.True(t, sessionExpired(time.Now().Add(-2*time.Minute)))
+	assert.False(t, claimsExpired(Claims{Exp: int(time.Now().Add(-30 * time.Second).Unix())}))
+	assert.True(t, claimsExpired(Claims{Exp: int(time.Now().Add(-2 * time.Minute).Unix())}))
+}
@@ -0,0 +1,67 @@
+package application
+
+import (
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+// deleteExpiredSession deletes the session s of r when its claims c expired, if enabled,
+// and returns whether it was deleted. Redis sessions expire on their own, but their claims
+// can expire before them, and filesystem sessions are only removed by the cleanup.
+func (a *Application) deleteExpiredSession(r *http.Request, s *sessions.Session, c Claims) bool {
+	if !config.Get().Proxy.SessionDeleteExpiredOnRead || s.ID == "" || !claimsExpired(c) {
+		return false
+	}
+	if err := a.LogoutSession(r.Context(), s.ID); err != nil {
+		a.log.WithError(err).Warning("failed to delete expired session")
+		return false
+	}
+	a.log.WithField("sub", c.Sub).Debug("deleted expired session")
+	// The session is saved as a new session if it is used later in the request
+	s.ID = ""
+	s.IsNew = true
+	clear(s.Values)
+	return true
+}
+
+// deletePastDeadlineSession deletes the session s of r when the absolute deadline of its
+// claims c passed, and returns whether it was deleted. Unlike expired claims, this is
+// always enforced, so that sessions refreshed by activity never outlive their deadline.
+func (a *Application) deletePastDeadlineSession(r *http.Request, s *sessions.Session, c Claims) bool {
+	if s.ID == "" || !deadlinePassed(c) {
+		return false
+	}
+	a.log.WithField("sub", c.Sub).Debug("session deadline passed, deleting session")
+	a.discardSession(r, s)
+	return true
+}
+
+// deleteExpiredCookieSession deletes the filesystem session of r if enabled, when its
+// session cookie couldn't be decoded because it expired. The cookie contains the session
+// ID, which is decoded again without checking its timestamp.
+func (a *Application) deleteExpiredCookieSession(r *http.Request) {
+	if !config.Get().Proxy.SessionDeleteExpiredOnRead {
+		return
+	}
+	store, ok := a.sessionStore().(*filesystemstore.FilesystemStore)
+	if !ok {
+		return
+	}
+	cookie, err := r.Cookie(a.SessionName())
+	if err != nil {
+		return
+	}
+	var id string
+	if securecookie.DecodeMulti(a.SessionName(), cookie.Value, &id, cookieSecretCodecs(0, a.proxyConfig)...) != nil {
+		return
+	}
+	if err := store.Delete(id); err != nil {
+		a.log.WithError(err).Warning("failed to delete expired session")
+		return
+	}
+	a.log.Debug("deleted expired session")
+}
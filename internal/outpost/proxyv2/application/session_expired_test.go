@@ -0,0 +1,102 @@
+package application
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+func TestDeleteExpiredSession(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionDeleteExpiredOnRead = false
+	}()
+	a := newTestApplication(t)
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo", Exp: int(time.Now().Add(-time.Hour).Unix())}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	cookie := rr.Result().Cookies()[0]
+	read := func() bool {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		req.AddCookie(cookie)
+		_, ok := a.ClaimsFromRequest(req)
+		return ok
+	}
+
+	// Expired sessions are kept unless enabled
+	assert.True(t, read())
+	assert.FileExists(t, store.Filename(s.ID))
+
+	config.Get().Proxy.SessionDeleteExpiredOnRead = true
+	assert.False(t, read())
+	assert.NoFileExists(t, store.Filename(s.ID))
+}
+
+func TestDeleteExpiredCookieSession(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionDeleteExpiredOnRead = true
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionDeleteExpiredOnRead = false
+	}()
+	a := newTestApplication(t)
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+
+	// The session ID is decoded from the cookie regardless of its timestamp
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.AddCookie(rr.Result().Cookies()[0])
+	a.deleteExpiredCookieSession(req)
+	assert.NoFileExists(t, store.Filename(s.ID))
+}
+
+func TestDeletePastDeadlineSession(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.IdleTimeout = time.Hour
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.IdleTimeout = 0
+	}()
+	a := newTestApplication(t)
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	claims := Claims{
+		Sub:       "foo",
+		Exp:       int(time.Now().Add(time.Hour).Unix()),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	s.Values[constants.SessionClaims] = claims
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	cookie := rr.Result().Cookies()[0]
+	read := func() bool {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		req.AddCookie(cookie)
+		_, ok := a.ClaimsFromRequest(req)
+		return ok
+	}
+	assert.True(t, read())
+
+	// The deadline is enforced even though the session expires later on its own
+	claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	s.Values[constants.SessionClaims] = claims
+	assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	assert.False(t, read())
+	assert.NoFileExists(t, store.Filename(s.ID))
+}
@@ -0,0 +1,285 @@
+package application
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// sessionExportVersion is the version of the format written by ExportSessions
+const sessionExportVersion = 1
+
+// sessionExportHeader is written once at the start of an export
+type sessionExportHeader struct {
+	Version int
+	// Backend the sessions were exported from
+	Backend string
+}
+
+// sessionExportRecord is written for every exported session
+type sessionExportRecord struct {
+	// Key is the storage ID of the session, see storageID
+	Key string
+	// Expires is the Unix time at which the session expires, zero when it doesn't
+	Expires int64
+	// Values are the session values, serialized with redisstore.GobSerializer
+	Values []byte
+}
+
+// ExportSessions writes the sessions of this application which haven't expired and
+// weren't logged out to w, along with their storage keys and absolute expiry, and returns
+// the number of exported sessions. Sessions of other applications sharing the session
+// directory or the Redis key prefix can't be decoded and are skipped. The export contains
+// the session values in clear text and must be protected like the cookie secret.
+func (a *Application) ExportSessions(ctx context.Context, w io.Writer) (int, error) {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(sessionExportHeader{Version: sessionExportVersion, Backend: a.sessionBackend()}); err != nil {
+		return 0, err
+	}
+	exported := 0
+	var encodeErr error
+	err := a.walkExportSessions(ctx, func(key string, expires time.Time, values map[interface{}]interface{}) bool {
+		if tombstoned(values) || sessionExpired(expires) {
+			return true
+		}
+		b, err := redisstore.GobSerializer{}.Serialize(&sessions.Session{Values: values})
+		if err != nil {
+			a.log.WithError(err).WithField("key", key).Warning("failed to serialize session")
+			return true
+		}
+		rec := sessionExportRecord{Key: key, Values: b}
+		if !expires.IsZero() {
+			rec.Expires = expires.Unix()
+		}
+		if encodeErr = enc.Encode(rec); encodeErr != nil {
+			return false
+		}
+		exported++
+		return true
+	})
+	if encodeErr != nil {
+		return exported, encodeErr
+	}
+	return exported, err
+}
+
+// walkExportSessions calls fn with the storage ID, the expiry and the values of every
+// session of this application until fn returns false. The expiry is the earliest of when
+// the session expires in the store and when its claims, its deadline or its grace period
+// expire, and zero when the session doesn't expire.
+func (a *Application) walkExportSessions(ctx context.Context, fn func(key string, expires time.Time, values map[interface{}]interface{}) bool) error {
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		files, err := os.ReadDir(store.Path())
+		if err != nil {
+			return err
+		}
+		// Decode without checking the timestamp like the cleanup, which removes files
+		// by their expiry
+		cs := cookieSecretCodecs(0, a.proxyConfig)
+		maxAge := time.Duration(store.Options.MaxAge) * time.Second
+		for _, file := range files {
+			key, ok := store.SessionID(file.Name())
+			if !ok {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				continue
+			}
+			data, err := store.ReadFile(path.Join(store.Path(), file.Name()))
+			if err != nil {
+				a.log.WithError(err).Warning("failed to read file")
+				continue
+			}
+			values := map[interface{}]interface{}{}
+			if securecookie.DecodeMulti(a.SessionName(), data, &values, cs...) != nil {
+				continue
+			}
+			if !fn(key, sessionFileExpiry(info.ModTime(), maxAge, values), values) {
+				return nil
+			}
+		}
+	case *memorystore.MemoryStore:
+		store.Range(func(id string, values map[interface{}]interface{}) bool {
+			expires, ok := store.Expires(id)
+			if !ok {
+				return true
+			}
+			return fn(id, earliestExpiry(expires, sessionFileExpiry(time.Time{}, 0, values)), values)
+		})
+	case *redisstore.RedisStore:
+		client := store.Client()
+		serializer := a.redisSerializer(client)
+		prefix := a.redisKeyPrefix()
+		err := store.Scan(ctx, func(keys []string) error {
+			for _, key := range keys {
+				var b []byte
+				var ttl time.Duration
+				err := withRedisRetry(ctx, func(ctx context.Context) error {
+					pipe := client.Pipeline()
+					get := pipe.Get(ctx, key)
+					pttl := pipe.PTTL(ctx, key)
+					if _, err := pipe.Exec(ctx); err != nil {
+						return err
+					}
+					b, _ = get.Bytes()
+					ttl = pttl.Val()
+					return nil
+				})
+				if errors.Is(err, redis.Nil) {
+					// The session expired or was deleted since it was scanned
+					continue
+				}
+				if err != nil {
+					a.log.WithError(err).WithField("key", key).Warning("failed to get value")
+					continue
+				}
+				// Serializers are passed sessions with their storage ID, see RedisStore.StorageID
+				s := &sessions.Session{
+					ID:      strings.TrimPrefix(key, prefix),
+					Values:  map[interface{}]interface{}{},
+					Options: &sessions.Options{},
+				}
+				if err := serializer.Deserialize(b, s); err != nil {
+					a.countDecodeError()
+					a.log.WithError(err).Warning("failed to deserialize")
+					continue
+				}
+				var expires time.Time
+				if ttl > 0 {
+					expires = time.Now().Add(ttl)
+				}
+				if !fn(s.ID, earliestExpiry(expires, sessionFileExpiry(time.Time{}, 0, s.Values)), s.Values) {
+					return errStopWalk
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, errStopWalk) {
+			return nil
+		}
+		return err
+	case LogoutStore:
+		return store.Walk(ctx, func(id string, values map[interface{}]interface{}) bool {
+			return fn(id, sessionFileExpiry(time.Time{}, 0, values), values)
+		})
+	default:
+		return fmt.Errorf("session backend %s doesn't support exporting sessions", a.sessionBackend())
+	}
+	return nil
+}
+
+// earliestExpiry returns the earlier of the expiries a and b, where zero never expires
+func earliestExpiry(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// ImportSessions reads sessions written by ExportSessions from r and stores them in the
+// session backend of this application, replacing sessions stored under the same key, and
+// returns the number of imported sessions. Sessions which expired since they were exported
+// are skipped, and the others expire at the time they would have expired in the exporting
+// store. Sessions can be imported into another backend, but memory and custom stores don't
+// hash session IDs, so exports made with session ID hashing can't be used with them.
+func (a *Application) ImportSessions(ctx context.Context, r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	var header sessionExportHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("failed to read session export: %w", err)
+	}
+	if header.Version != sessionExportVersion {
+		return 0, fmt.Errorf("unsupported session export version %d", header.Version)
+	}
+	rec := &cookieRecorder{header: http.Header{}}
+	req, err := rec.request(ctx)
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for {
+		var exported sessionExportRecord
+		if err := dec.Decode(&exported); errors.Is(err, io.EOF) {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("failed to read session export: %w", err)
+		}
+		var expires time.Time
+		if exported.Expires != 0 {
+			expires = time.Unix(exported.Expires, 0)
+		}
+		if sessionExpired(expires) {
+			continue
+		}
+		// A new session carries the default options of the store
+		s, err := a.sessionStore().New(req, a.SessionName())
+		if err != nil {
+			return imported, err
+		}
+		if err := (redisstore.GobSerializer{}).Deserialize(exported.Values, s); err != nil {
+			return imported, fmt.Errorf("failed to deserialize session %s: %w", exported.Key, err)
+		}
+		s.ID = exported.Key
+		s.IsNew = false
+		if !expires.IsZero() {
+			s.Options.MaxAge = max(int(math.Ceil(time.Until(expires).Seconds())), 1)
+		}
+		if err := a.importSession(ctx, req, rec, s, expires); err != nil {
+			return imported, fmt.Errorf("failed to import session %s: %w", exported.Key, err)
+		}
+		imported++
+	}
+}
+
+// importSession stores the session s under its ID, which is its storage ID, so that it
+// expires at expires
+func (a *Application) importSession(ctx context.Context, r *http.Request, w http.ResponseWriter, s *sessions.Session, expires time.Time) error {
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		encoded, err := securecookie.EncodeMulti(a.SessionName(), s.Values, store.Codecs...)
+		if err != nil {
+			return err
+		}
+		filename := store.Filename(s.ID)
+		if err := store.WriteFile(filename, encoded); err != nil {
+			return err
+		}
+		if expires.IsZero() || store.Options.MaxAge <= 0 {
+			return nil
+		}
+		// Session files expire their max age after they were last modified
+		mtime := expires.Add(-time.Duration(store.Options.MaxAge) * time.Second)
+		if mtime.After(time.Now()) {
+			return nil
+		}
+		return os.Chtimes(filename, time.Time{}, mtime)
+	case *redisstore.RedisStore:
+		ctx, cancel := context.WithTimeout(ctx, redisTimeout())
+		defer cancel()
+		client := store.Client()
+		b, err := a.redisSerializer(client).Serialize(s)
+		if err != nil {
+			return err
+		}
+		return client.Set(ctx, a.redisKeyPrefix()+s.ID, b, time.Duration(max(s.Options.MaxAge, 0))*time.Second).Err()
+	}
+	// Memory and custom stores store sessions by their session ID
+	return a.sessionStore().Save(r, w, s)
+}
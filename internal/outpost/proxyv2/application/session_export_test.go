@@ -0,0 +1,106 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+func TestExportImportSessions(t *testing.T) {
+	for _, backend := range []string{SessionBackendFilesystem, SessionBackendMemory} {
+		t.Run(backend, func(t *testing.T) {
+			config.Get().Proxy.SessionBackend = backend
+			config.Get().Proxy.SessionDir = t.TempDir()
+			defer func() {
+				config.Get().Proxy.SessionBackend = ""
+				config.Get().Proxy.SessionDir = ""
+			}()
+			a := newTestApplication(t)
+			defer a.Stop()
+
+			req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+			cookies := map[string]*http.Cookie{}
+			for sub, expiresAt := range map[string]int64{
+				"foo": time.Now().Add(time.Hour).Unix(),
+				"bar": time.Now().Add(-time.Hour).Unix(),
+			} {
+				s, _ := a.sessions.New(req, a.SessionName())
+				s.Options.MaxAge = 86400
+				s.Values[constants.SessionClaims] = Claims{Sub: sub, ExpiresAt: expiresAt}
+				rr := httptest.NewRecorder()
+				assert.NoError(t, a.sessions.Save(req, rr, s))
+				cookies[sub] = rr.Result().Cookies()[0]
+			}
+			claims := func(sub string) (Claims, bool) {
+				r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+				r.AddCookie(cookies[sub])
+				return a.ClaimsFromRequest(r)
+			}
+
+			// Sessions past their deadline aren't exported
+			buf := bytes.Buffer{}
+			exported, err := a.ExportSessions(context.Background(), &buf)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, exported)
+
+			_, err = a.LogoutAll(context.Background())
+			assert.NoError(t, err)
+			_, ok := claims("foo")
+			assert.False(t, ok)
+
+			imported, err := a.ImportSessions(context.Background(), &buf)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, imported)
+			c, ok := claims("foo")
+			assert.True(t, ok)
+			assert.Equal(t, "foo", c.Sub)
+			_, ok = claims("bar")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestImportSessions_Expired(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+
+	values, err := redisstore.GobSerializer{}.Serialize(&sessions.Session{
+		Values: map[interface{}]interface{}{constants.SessionClaims: Claims{Sub: "foo"}},
+	})
+	assert.NoError(t, err)
+	buf := bytes.Buffer{}
+	enc := gob.NewEncoder(&buf)
+	assert.NoError(t, enc.Encode(sessionExportHeader{Version: sessionExportVersion}))
+	assert.NoError(t, enc.Encode(sessionExportRecord{Key: "expired", Expires: time.Now().Add(-time.Hour).Unix(), Values: values}))
+	assert.NoError(t, enc.Encode(sessionExportRecord{Key: "valid", Expires: time.Now().Add(time.Hour).Unix(), Values: values}))
+
+	imported, err := a.ImportSessions(context.Background(), &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	all, err := a.Sessions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	// The TTL is recomputed from the absolute expiry
+	expires, ok := a.sessions.(*memorystore.MemoryStore).Expires("valid")
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+
+	_, err = a.ImportSessions(context.Background(), bytes.NewReader(nil))
+	assert.Error(t, err)
+}
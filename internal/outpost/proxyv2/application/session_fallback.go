@@ -0,0 +1,129 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/prometheus/client_golang/prometheus"
+	"goauthentik.io/api/v3"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/metrics"
+)
+
+// redisFallbackRetryInterval is how often Redis is connected to again while a fallback
+// store is used
+var redisFallbackRetryInterval = 30 * time.Second
+
+// sessionStore returns the session store of the application
+func (a *Application) sessionStore() sessions.Store {
+	a.sessionsMutex.RLock()
+	defer a.sessionsMutex.RUnlock()
+	return a.sessions
+}
+
+// redisFallbackOptions returns the options of the Redis store while a fallback store is
+// used, or nil
+func (a *Application) redisFallbackOptions() *sessions.Options {
+	a.sessionsMutex.RLock()
+	defer a.sessionsMutex.RUnlock()
+	return a.redisFallback
+}
+
+// EffectiveSessionBackend returns the backend sessions are currently stored in, which
+// differs from the configured backend while a fallback store is used
+func (a *Application) EffectiveSessionBackend() string {
+	a.sessionsMutex.RLock()
+	defer a.sessionsMutex.RUnlock()
+	return a.effectiveBackend
+}
+
+// OnSessionBackendChange sets a function which is called with the configured and the
+// effective session backend whenever the effective backend changes. It is called right
+// away when the effective backend already differs from the configured one.
+func (a *Application) OnSessionBackendChange(fn func(expected, effective string)) {
+	a.sessionsMutex.Lock()
+	a.onBackendChange = fn
+	effective := a.effectiveBackend
+	a.sessionsMutex.Unlock()
+	if expected := a.sessionBackend(); fn != nil && effective != "" && effective != expected {
+		fn(expected, effective)
+	}
+}
+
+// setEffectiveBackend records the backend sessions are stored in, and reports whether it
+// differs from the configured backend
+func (a *Application) setEffectiveBackend(effective string) {
+	a.sessionsMutex.Lock()
+	changed := a.effectiveBackend != effective
+	a.effectiveBackend = effective
+	fn := a.onBackendChange
+	a.sessionsMutex.Unlock()
+	expected := a.sessionBackend()
+	fallback := 0.0
+	if effective != expected {
+		fallback = 1
+	}
+	metrics.SessionBackendFallback.With(prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"application":  a.proxyConfig.AssignedApplicationSlug,
+	}).Set(fallback)
+	if changed && fn != nil {
+		fn(expected, effective)
+	}
+}
+
+// getFallbackStore returns the configured fallback store and its backend when Redis is
+// unavailable with redisErr, and retries connecting to Redis in the background until the
+// fallback store can be replaced. redisErr is returned when no fallback is configured.
+func (a *Application) getFallbackStore(p api.ProxyOutpostConfig, maxAge int, opts sessions.Options, redisErr error) (sessions.Store, string, error) {
+	var store sessions.Store
+	var err error
+	backend := strings.ToLower(config.Get().Proxy.SessionRedisFallback)
+	switch backend {
+	case "":
+		return nil, SessionBackendRedis, redisErr
+	case SessionBackendMemory:
+		store = newMemoryStore(opts)
+	case SessionBackendFilesystem:
+		store, err = a.getFilesystemStore(p, maxAge, opts)
+	default:
+		return nil, backend, fmt.Errorf("%w %q, fallback must be one of filesystem or memory", ErrUnsupportedBackend, backend)
+	}
+	if err != nil {
+		return nil, backend, errors.Join(redisErr, err)
+	}
+	a.log.WithError(redisErr).WithField("fallback", backend).Error("redis is unavailable, storing sessions in the fallback backend until redis is available")
+	a.redisFallback = &opts
+	go a.retryRedisStore(opts)
+	return store, backend, nil
+}
+
+// retryRedisStore connects to Redis until it is available or the application is stopped,
+// and then replaces the fallback store. Sessions of the fallback store are lost.
+func (a *Application) retryRedisStore(opts sessions.Options) {
+	ticker := time.NewTicker(redisFallbackRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+		store, err := a.getRedisStore(opts)
+		if err != nil {
+			a.log.WithError(err).Debug("redis is still unavailable")
+			continue
+		}
+		a.sessionsMutex.Lock()
+		a.sessions = store
+		a.redisFallback = nil
+		a.sessionsMutex.Unlock()
+		a.log.Warning("redis is available again, storing sessions in redis, users who logged in meanwhile have to log in again")
+		a.logStoreOptions(store, SessionBackendRedis, opts)
+		a.setEffectiveBackend(SessionBackendRedis)
+		return
+	}
+}
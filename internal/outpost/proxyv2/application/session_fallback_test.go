@@ -0,0 +1,74 @@
+package application
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+)
+
+func TestGetStore_RedisFallback(t *testing.T) {
+	a := newTestApplication(t)
+	rc := config.Get().Redis
+	config.Get().Proxy.SessionBackend = SessionBackendRedis
+	config.Get().Redis.Host = "127.0.0.1"
+	config.Get().Redis.Port = 1
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+		config.Get().Proxy.SessionRedisFallback = ""
+		config.Get().Redis = rc
+	}()
+	// Stop retrying before the config is reset
+	defer a.Stop()
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+
+	// Without a fallback the store can't be created
+	_, err := a.getStore(a.proxyConfig, u)
+	assert.ErrorIs(t, err, ErrRedisUnavailable)
+
+	config.Get().Proxy.SessionRedisFallback = "memory"
+	store, err := a.getStore(a.proxyConfig, u)
+	assert.NoError(t, err)
+	assert.IsType(t, &memorystore.MemoryStore{}, store)
+	assert.NotNil(t, a.redisFallbackOptions())
+
+	config.Get().Proxy.SessionRedisFallback = "foo"
+	_, err = a.getStore(a.proxyConfig, u)
+	assert.ErrorIs(t, err, ErrUnsupportedBackend)
+}
+
+func TestOnSessionBackendChange(t *testing.T) {
+	a := newTestApplication(t)
+	assert.Equal(t, SessionBackendFilesystem, a.EffectiveSessionBackend())
+	changes := [][2]string{}
+	a.OnSessionBackendChange(func(expected, effective string) {
+		changes = append(changes, [2]string{expected, effective})
+	})
+	assert.Empty(t, changes)
+
+	rc := config.Get().Redis
+	config.Get().Proxy.SessionBackend = SessionBackendRedis
+	config.Get().Proxy.SessionRedisFallback = "memory"
+	config.Get().Redis.Host = "127.0.0.1"
+	config.Get().Redis.Port = 1
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+		config.Get().Proxy.SessionRedisFallback = ""
+		config.Get().Redis = rc
+	}()
+	defer a.Stop()
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	_, err := a.getStore(a.proxyConfig, u)
+	assert.NoError(t, err)
+	assert.Equal(t, SessionBackendMemory, a.EffectiveSessionBackend())
+	assert.Equal(t, [][2]string{{SessionBackendRedis, SessionBackendMemory}}, changes)
+
+	// Functions set while a fallback store is used are called right away
+	called := false
+	a.OnSessionBackendChange(func(expected, effective string) {
+		called = expected == SessionBackendRedis && effective == SessionBackendMemory
+	})
+	assert.True(t, called)
+}
@@ -0,0 +1,75 @@
+package application
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+)
+
+// getSession returns the session with the given name of the request. When enabled, the
+// Secure attribute of the session cookie is derived from the scheme forwarded by a
+// trusted proxy instead of the scheme of the external host.
+func (a *Application) getSession(r *http.Request, name string) (*sessions.Session, error) {
+	s, err := a.sessionStore().Get(r, name)
+	if s != nil && s.Options != nil {
+		a.applyForwardedProto(r, s.Options)
+	}
+	return s, err
+}
+
+// applyForwardedProto sets the Secure attribute of opts from the X-Forwarded-Proto header,
+// if the outpost is configured to trust it, the request comes from a trusted proxy and the
+// Secure attribute isn't overridden or required by a cookie prefix. SameSite=None is only
+// kept for secure cookies.
+func (a *Application) applyForwardedProto(r *http.Request, opts *sessions.Options) {
+	if !config.Get().Proxy.TrustForwardedProto || a.cookiePrefix() != "" {
+		return
+	}
+	ac := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug)
+	if force := strings.ToLower(ac.CookieForceSecure); force != "" && force != "auto" {
+		return
+	}
+	proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+	if proto != "http" && proto != "https" {
+		return
+	}
+	if !isTrustedProxy(r.RemoteAddr) {
+		return
+	}
+	secure := proto == "https"
+	if secure == opts.Secure {
+		return
+	}
+	opts.Secure = secure
+	if strings.EqualFold(ac.CookieSameSite, "none") {
+		opts.SameSite = http.SameSiteLaxMode
+		if secure {
+			opts.SameSite = http.SameSiteNoneMode
+		}
+	}
+}
+
+// isTrustedProxy returns whether remoteAddr is within one of the trusted proxy CIDRs
+func isTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, rn := range config.Get().Listen.TrustedProxyCIDRs {
+		_, cidr, err := net.ParseCIDR(rn)
+		if err != nil {
+			continue
+		}
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
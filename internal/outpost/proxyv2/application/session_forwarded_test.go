@@ -0,0 +1,49 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+)
+
+func TestApplyForwardedProto(t *testing.T) {
+	a := newTestApplication(t)
+	cidrs := config.Get().Listen.TrustedProxyCIDRs
+	config.Get().Listen.TrustedProxyCIDRs = []string{"10.0.0.0/8"}
+	defer func() {
+		config.Get().Listen.TrustedProxyCIDRs = cidrs
+		config.Get().Proxy.TrustForwardedProto = false
+		config.Get().Proxy.CookieSameSite = ""
+		config.Get().Proxy.CookieForceSecure = ""
+	}()
+
+	apply := func(remoteAddr string, proto string) sessions.Options {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-Proto", proto)
+		opts := sessions.Options{Secure: true, SameSite: http.SameSiteNoneMode}
+		a.applyForwardedProto(req, &opts)
+		return opts
+	}
+
+	// The header is ignored unless enabled
+	assert.True(t, apply("10.0.0.1:1234", "http").Secure)
+
+	config.Get().Proxy.TrustForwardedProto = true
+	config.Get().Proxy.CookieSameSite = "none"
+	opts := apply("10.0.0.1:1234", "http")
+	assert.False(t, opts.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, opts.SameSite)
+	assert.True(t, apply("10.0.0.1:1234", "https, http").Secure)
+	// Untrusted clients and unknown schemes are ignored
+	assert.True(t, apply("192.0.2.1:1234", "http").Secure)
+	assert.True(t, apply("10.0.0.1:1234", "ws").Secure)
+
+	// An explicit override takes precedence
+	config.Get().Proxy.CookieForceSecure = "true"
+	assert.True(t, apply("10.0.0.1:1234", "http").Secure)
+}
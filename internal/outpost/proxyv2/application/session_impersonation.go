@@ -0,0 +1,62 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	"goauthentik.io/internal/config"
+)
+
+// defaultImpersonationSessionMaxAge is how long sessions created while an admin
+// impersonates the user last at most, unless configured otherwise
+const defaultImpersonationSessionMaxAge = 1 * time.Hour
+
+// actorClaims holds the actor claim of a token, which authentik sets to the admin
+// impersonating the user, see RFC 8693
+type actorClaims struct {
+	Act *struct {
+		Sub string `json:"sub"`
+	} `json:"act"`
+}
+
+// impersonationMaxAge returns the maximum duration of this application's impersonation
+// sessions, zero when they are limited like other sessions
+func (a *Application) impersonationMaxAge() time.Duration {
+	limit := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).ImpersonationSessionMaxAge
+	if limit < 0 {
+		return 0
+	}
+	if limit == 0 {
+		return defaultImpersonationSessionMaxAge
+	}
+	return limit
+}
+
+// claimsDeadline returns the absolute deadline of a new session with claims c, like
+// sessionDeadline, and no later than the impersonation max age for impersonation sessions
+func (a *Application) claimsDeadline(c Claims) int64 {
+	deadline := a.sessionDeadline(c.CreatedAt)
+	limit := a.impersonationMaxAge()
+	if !c.Impersonated() || limit <= 0 {
+		return deadline
+	}
+	impersonation := time.Unix(c.CreatedAt, 0).Add(limit).Unix()
+	if deadline == 0 || impersonation < deadline {
+		return impersonation
+	}
+	return deadline
+}
+
+// ImpersonatedBy returns a Logout filter matching sessions created while the admin with the
+// given subject impersonated a user, or while any admin did when sub is empty
+func ImpersonatedBy(sub string) func(c Claims) bool {
+	return func(c Claims) bool {
+		return c.Impersonated() && (sub == "" || c.ImpersonatorSubject == sub)
+	}
+}
+
+// LogoutImpersonations deletes all sessions created while an admin impersonated a user,
+// and returns the number of sessions which were deleted
+func (a *Application) LogoutImpersonations(ctx context.Context) (int, error) {
+	return a.LogoutCount(ctx, ImpersonatedBy(""))
+}
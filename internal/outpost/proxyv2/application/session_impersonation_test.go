@@ -0,0 +1,84 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+)
+
+func TestActorClaims(t *testing.T) {
+	var actor actorClaims
+	assert.NoError(t, json.Unmarshal([]byte(`{"sub": "foo", "act": {"sub": "admin"}}`), &actor))
+	assert.Equal(t, "admin", actor.Act.Sub)
+	actor = actorClaims{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"sub": "foo"}`), &actor))
+	assert.Nil(t, actor.Act)
+}
+
+func TestClaimsDeadline(t *testing.T) {
+	defer func() {
+		config.Get().Proxy.SessionMaxAge = 0
+		config.Get().Proxy.ImpersonationSessionMaxAge = 0
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	now := time.Now().Unix()
+	impersonated := Claims{CreatedAt: now, ImpersonatorSubject: "admin"}
+
+	// Impersonation sessions are limited to an hour by default
+	assert.Equal(t, int64(0), a.claimsDeadline(Claims{CreatedAt: now}))
+	assert.Equal(t, now+3600, a.claimsDeadline(impersonated))
+
+	config.Get().Proxy.ImpersonationSessionMaxAge = 10 * time.Minute
+	assert.Equal(t, now+600, a.claimsDeadline(impersonated))
+
+	// The session max age applies when it is shorter
+	config.Get().Proxy.SessionMaxAge = 5 * time.Minute
+	assert.Equal(t, now+300, a.claimsDeadline(impersonated))
+
+	config.Get().Proxy.ImpersonationSessionMaxAge = -1
+	config.Get().Proxy.SessionMaxAge = 0
+	assert.Equal(t, int64(0), a.claimsDeadline(impersonated))
+}
+
+func TestImpersonatedBy(t *testing.T) {
+	assert.True(t, ImpersonatedBy("")(Claims{ImpersonatorSubject: "admin"}))
+	assert.True(t, ImpersonatedBy("admin")(Claims{ImpersonatorSubject: "admin"}))
+	assert.False(t, ImpersonatedBy("other")(Claims{ImpersonatorSubject: "admin"}))
+	assert.False(t, ImpersonatedBy("")(Claims{Sub: "admin"}))
+}
+
+func TestLogoutImpersonations(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	for _, c := range []Claims{
+		{Sub: "foo"},
+		{Sub: "foo", ImpersonatorSubject: "admin"},
+		{Sub: "bar", ImpersonatorSubject: "admin"},
+	} {
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = c
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	}
+
+	deleted, err := a.LogoutImpersonations(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	all, err := a.Sessions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+	assert.False(t, all[0].Impersonated())
+}
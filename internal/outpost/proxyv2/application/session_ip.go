@@ -0,0 +1,110 @@
+package application
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+)
+
+// sessionClientIP is the session value holding the IP of the client which created the session
+const sessionClientIP = "ak_client_ip"
+
+const (
+	// SessionIPBindingIP rejects sessions presented from another IP than the one they
+	// were created from
+	SessionIPBindingIP = "ip"
+	// SessionIPBindingSubnet rejects sessions presented from outside of the /24 IPv4 or
+	// /64 IPv6 network they were created from
+	SessionIPBindingSubnet = "subnet"
+)
+
+// sessionIPBinding returns the configured session IP binding, which is empty when sessions
+// aren't bound to the IP of their client
+func sessionIPBinding(mode string) (string, error) {
+	switch mode = strings.ToLower(mode); mode {
+	case "", SessionIPBindingIP, SessionIPBindingSubnet:
+		return mode, nil
+	default:
+		return "", ErrInvalidSessionIPBinding
+	}
+}
+
+// clientIP returns the IP of the client of r. For requests from trusted proxies, this is
+// the last address in X-Forwarded-For which isn't a trusted proxy itself.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// bindClientIP stores the IP of the client of r in s, when the user logs in
+func bindClientIP(r *http.Request, s *sessions.Session) {
+	s.Values[sessionClientIP] = clientIP(r)
+}
+
+// sameClientNetwork returns whether ip matches the IP bound, according to binding
+func sameClientNetwork(binding string, bound string, ip string) bool {
+	boundIP, reqIP := net.ParseIP(bound), net.ParseIP(ip)
+	if boundIP == nil || reqIP == nil {
+		return bound == ip
+	}
+	if binding != SessionIPBindingSubnet {
+		return boundIP.Equal(reqIP)
+	}
+	mask := net.CIDRMask(64, 128)
+	if v4 := boundIP.To4(); v4 != nil {
+		boundIP, mask = v4, net.CIDRMask(24, 32)
+	}
+	network := net.IPNet{IP: boundIP.Mask(mask), Mask: mask}
+	return network.Contains(reqIP)
+}
+
+// deleteIPMismatchSession deletes the session s of r when it is bound to another client IP
+// than the one of r, and returns whether it was deleted. Sessions without a client IP,
+// such as sessions created before the binding was enabled, aren't rejected.
+func (a *Application) deleteIPMismatchSession(r *http.Request, s *sessions.Session, c Claims) bool {
+	ac := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug)
+	binding, _ := sessionIPBinding(ac.SessionIPBinding)
+	bound, ok := s.Values[sessionClientIP].(string)
+	if binding == "" || !ok || s.ID == "" {
+		return false
+	}
+	ip := clientIP(r)
+	if sameClientNetwork(binding, bound, ip) {
+		return false
+	}
+	a.log.WithField("sub", c.Sub).WithField("bound", bound).WithField("ip", ip).Warning("session presented from another client IP, deleting session")
+	a.discardSession(r, s)
+	return true
+}
+
+// discardSession deletes the rejected session s of r. The session is rejected even if it
+// fails to be deleted.
+func (a *Application) discardSession(r *http.Request, s *sessions.Session) {
+	if err := a.LogoutSession(r.Context(), s.ID); err != nil {
+		a.log.WithError(err).Warning("failed to delete session")
+	}
+	// The session is saved as a new session if it is used later in the request
+	s.ID = ""
+	s.IsNew = true
+	clear(s.Values)
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+)
+
+func TestClientIP(t *testing.T) {
+	cidrs := config.Get().Listen.TrustedProxyCIDRs
+	config.Get().Listen.TrustedProxyCIDRs = []string{"10.0.0.0/8"}
+	defer func() {
+		config.Get().Listen.TrustedProxyCIDRs = cidrs
+	}()
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	// Forwarded IPs are ignored unless the request comes from a trusted proxy
+	assert.Equal(t, "192.0.2.1", clientIP(req))
+
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1, 192.0.2.1, 10.0.0.2")
+	assert.Equal(t, "192.0.2.1", clientIP(req))
+	req.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", clientIP(req))
+}
+
+func TestSameClientNetwork(t *testing.T) {
+	assert.True(t, sameClientNetwork(SessionIPBindingIP, "192.0.2.1", "192.0.2.1"))
+	assert.False(t, sameClientNetwork(SessionIPBindingIP, "192.0.2.1", "192.0.2.2"))
+	assert.True(t, sameClientNetwork(SessionIPBindingSubnet, "192.0.2.1", "192.0.2.200"))
+	assert.False(t, sameClientNetwork(SessionIPBindingSubnet, "192.0.2.1", "192.0.3.1"))
+	assert.True(t, sameClientNetwork(SessionIPBindingSubnet, "2001:db8::1", "2001:db8::ffff"))
+	assert.False(t, sameClientNetwork(SessionIPBindingSubnet, "2001:db8::1", "2001:db8:1::1"))
+}
+
+func TestDeleteIPMismatchSession(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionIPBinding = SessionIPBindingSubnet
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionIPBinding = ""
+	}()
+	a := newTestApplication(t)
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo"}
+	bindClientIP(req, s)
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	cookie := rr.Result().Cookies()[0]
+
+	// Requests from the same network are accepted
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	req.AddCookie(cookie)
+	_, ok := a.ClaimsFromRequest(req)
+	assert.True(t, ok)
+
+	// Requests from another network are rejected, and the session is deleted
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.AddCookie(cookie)
+	_, ok = a.ClaimsFromRequest(req)
+	assert.False(t, ok)
+	req = httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.AddCookie(cookie)
+	_, ok = a.ClaimsFromRequest(req)
+	assert.False(t, ok)
+}
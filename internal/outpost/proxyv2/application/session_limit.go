@@ -0,0 +1,102 @@
+package application
+
+import (
+	"context"
+	"sort"
+
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// sessionKey returns the identifier of the session with the given ID as passed to
+// walkSessions, which is the file path for filesystem sessions and the key for redis
+// sessions
+func (a *Application) sessionKey(id string) string {
+	switch a.sessionStore().(type) {
+	case *memorystore.MemoryStore, *postgresstore.PostgresStore:
+		// Sessions are stored under their session ID regardless of session ID hashing
+		return id
+	}
+	return a.storageKey(a.storageID(id))
+}
+
+// storageKey returns the identifier as passed to walkSessions of the session stored under
+// the given storage ID, or of all sessions whose storage ID starts with it
+func (a *Application) storageKey(id string) string {
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		return store.Filename(id)
+	case *redisstore.RedisStore:
+		return a.redisKeyPrefix() + id
+	}
+	return id
+}
+
+// enforceSessionLimit deletes the oldest sessions of the user with the given subject, so
+// that including the session with the given ID they hold at most the configured number of
+// sessions. Sessions are ordered by their creation time, and by their key for sessions
+// created at the same time. Returns the number of deleted sessions.
+func (a *Application) enforceSessionLimit(ctx context.Context, sub string, currentID string) (int, error) {
+	limit := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).MaxSessionsPerUser
+	if limit <= 0 {
+		return 0, nil
+	}
+	type userSession struct {
+		key       string
+		createdAt int64
+	}
+	current := a.sessionKey(currentID)
+	existing := []userSession{}
+	// SCAN may return the same key more than once
+	seen := map[string]struct{}{}
+	err := a.walkSessions(ctx, func(id string, claims Claims) bool {
+		if _, ok := seen[id]; ok || id == current || claims.Sub != sub {
+			return true
+		}
+		seen[id] = struct{}{}
+		existing = append(existing, userSession{key: id, createdAt: claims.CreatedAt})
+		return true
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	// The current session takes up one of the allowed sessions
+	excess := len(existing) - (limit - 1)
+	if excess <= 0 {
+		return 0, nil
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		if existing[i].createdAt != existing[j].createdAt {
+			return existing[i].createdAt < existing[j].createdAt
+		}
+		return existing[i].key < existing[j].key
+	})
+	keys := make([]string, 0, excess)
+	for _, s := range existing[:excess] {
+		keys = append(keys, s.key)
+	}
+	a.log.WithField("sub", sub).WithField("count", len(keys)).Info("evicting sessions exceeding the per-user limit")
+	if rs, ok := a.sessionStore().(*redisstore.RedisStore); ok {
+		deleted, _ := a.deleteRedisSessions(ctx, rs, keys)
+		return deleted, nil
+	}
+	if _, ok := a.sessionStore().(*filesystemstore.FilesystemStore); ok {
+		sessionSweepMutex.Lock()
+		defer sessionSweepMutex.Unlock()
+	}
+	deleted := 0
+	for _, key := range keys {
+		ok, err := a.deleteSession(key)
+		if err != nil {
+			a.log.WithError(err).WithField("id", key).Warning("failed to delete session")
+			continue
+		}
+		if ok {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
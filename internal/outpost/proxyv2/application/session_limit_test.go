@@ -0,0 +1,56 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+)
+
+func TestEnforceSessionLimit(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.MaxSessionsPerUser = 0
+	}()
+	a := newTestApplication(t)
+
+	save := func(claims Claims) string {
+		req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = claims
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+		return s.ID
+	}
+	for _, createdAt := range []int64{3, 1, 2} {
+		save(Claims{Sub: "foo", CreatedAt: createdAt})
+	}
+	save(Claims{Sub: "bar", CreatedAt: 1})
+	current := save(Claims{Sub: "foo", CreatedAt: 4})
+
+	// Without a limit, no sessions are deleted
+	deleted, err := a.enforceSessionLimit(context.Background(), "foo", current)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	config.Get().Proxy.MaxSessionsPerUser = 2
+	deleted, err = a.enforceSessionLimit(context.Background(), "foo", current)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	sessions, err := a.Sessions(context.Background())
+	assert.NoError(t, err)
+	remaining := []string{}
+	for _, c := range sessions {
+		remaining = append(remaining, fmt.Sprintf("%s-%d", c.Sub, c.CreatedAt))
+	}
+	sort.Strings(remaining)
+	assert.Equal(t, []string{"bar-1", "foo-3", "foo-4"}, remaining)
+}
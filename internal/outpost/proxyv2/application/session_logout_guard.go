@@ -0,0 +1,47 @@
+package application
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// logoutGuard lets one logout sweep of an application run at a time, so that repeated
+// logouts don't flood the session backend with scans and deletes. Further sweeps wait
+// for the running sweep to finish. The guard is shared with the application which
+// replaces this one when the outpost configuration is refreshed.
+type logoutGuard struct {
+	sem chan struct{}
+	// number of sweeps which are running or waiting
+	pending atomic.Int32
+}
+
+func newLogoutGuard() *logoutGuard {
+	return &logoutGuard{sem: make(chan struct{}, 1)}
+}
+
+// acquire waits until no other sweep is running, and returns a function which has to be
+// called once the sweep is done. An error is returned if ctx is done before.
+func (g *logoutGuard) acquire(ctx context.Context) (func(), error) {
+	g.pending.Add(1)
+	select {
+	case g.sem <- struct{}{}:
+		return func() {
+			<-g.sem
+			g.pending.Add(-1)
+		}, nil
+	case <-ctx.Done():
+		g.pending.Add(-1)
+		return nil, ctx.Err()
+	}
+}
+
+// LogoutInProgress returns whether a logout sweep of the application is running
+func (a *Application) LogoutInProgress() bool {
+	return a.logoutGuard.pending.Load() > 0
+}
+
+// PendingLogouts returns the number of logout sweeps of the application which are
+// running or waiting for another sweep to finish
+func (a *Application) PendingLogouts() int {
+	return int(a.logoutGuard.pending.Load())
+}
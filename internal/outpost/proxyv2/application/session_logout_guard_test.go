@@ -0,0 +1,47 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+)
+
+func TestLogoutGuard(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	assert.False(t, a.LogoutInProgress())
+
+	release, err := a.logoutGuard.acquire(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, a.LogoutInProgress())
+
+	// Sweeps wait for the running sweep, unless they are cancelled before
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.LogoutCount(ctx, func(c Claims) bool { return true })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, a.PendingLogouts())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := a.LogoutAll(context.Background())
+		assert.NoError(t, err)
+	}()
+	assert.Eventually(t, func() bool { return a.PendingLogouts() == 2 }, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("logout didn't wait for the running sweep")
+	default:
+	}
+	release()
+	<-done
+	assert.False(t, a.LogoutInProgress())
+}
@@ -0,0 +1,210 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+	"goauthentik.io/internal/outpost/proxyv2/metrics"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// sessionMetricsInterval is how often the number of sessions is counted, which
+// is done on a ticker to avoid scanning the session store on every request
+const sessionMetricsInterval = 1 * time.Minute
+
+// sessionMetricsOwners maps session stores to the application counting their sessions.
+// The store of an application is handed over to its replacement when the outposts'
+// providers are refreshed, which takes over counting its sessions.
+var sessionMetricsOwners sync.Map
+
+func (a *Application) runSessionMetrics() {
+	store := a.sessionStore()
+	// Stores which can't be map keys are counted by every application using them
+	if store != nil && reflect.TypeOf(store).Comparable() {
+		sessionMetricsOwners.Store(store, a)
+		defer func() {
+			// The gauge of an application which wasn't replaced would never be updated again
+			if sessionMetricsOwners.CompareAndDelete(store, a) {
+				metrics.Sessions.Delete(a.sessionMetricsLabels())
+			}
+		}()
+	}
+	ticker := time.NewTicker(sessionMetricsInterval)
+	defer ticker.Stop()
+	for {
+		if !a.ownsSessionMetrics(store) {
+			return
+		}
+		a.updateSessionMetrics()
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ownsSessionMetrics returns whether this application counts the sessions of store
+func (a *Application) ownsSessionMetrics(store any) bool {
+	if store == nil || !reflect.TypeOf(store).Comparable() {
+		return true
+	}
+	owner, _ := sessionMetricsOwners.Load(store)
+	return owner == a
+}
+
+func (a *Application) sessionMetricsLabels() prometheus.Labels {
+	return prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"application":  a.proxyConfig.AssignedApplicationSlug,
+	}
+}
+
+func (a *Application) updateSessionMetrics() {
+	// Counting the keys under a prefix shared with other applications would report the
+	// sessions of all of them for this application
+	if _, ok := a.sessionStore().(*redisstore.RedisStore); ok && a.redisKeyPrefixShared() {
+		metrics.Sessions.Delete(a.sessionMetricsLabels())
+		return
+	}
+	count, err := a.sessionCount(context.Background())
+	if err != nil {
+		a.log.WithError(err).Warning("failed to count sessions")
+		return
+	}
+	metrics.Sessions.With(a.sessionMetricsLabels()).Set(float64(count))
+}
+
+// countDecodeError records a session which failed to decode
+func (a *Application) countDecodeError() {
+	metrics.SessionDecodeErrors.With(prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"backend":      a.sessionBackend(),
+	}).Inc()
+}
+
+// storeInitFailureReason returns a short reason for the error with which the session store
+// failed to be created, to keep the cardinality of the failure metric low
+func storeInitFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRedisUnavailable):
+		return "redis_unavailable"
+	case errors.Is(err, ErrInvalidRedisURL):
+		return "invalid_redis_url"
+	case errors.Is(err, ErrPostgresUnavailable):
+		return "postgres_unavailable"
+	case errors.Is(err, ErrInvalidPostgresConfig):
+		return "invalid_postgres_config"
+	case errors.Is(err, ErrInvalidTLSConfig):
+		return "invalid_tls_config"
+	case errors.Is(err, ErrUnsupportedBackend):
+		return "unsupported_backend"
+	case errors.Is(err, ErrInvalidCookieOptions), errors.Is(err, ErrInvalidCookiePrefix):
+		return "invalid_cookie_options"
+	case errors.Is(err, ErrInvalidSessionInstance), errors.Is(err, fs.ErrPermission), errors.Is(err, fs.ErrNotExist):
+		return "session_dir"
+	default:
+		return "other"
+	}
+}
+
+// countStoreInitFailure records a session store which failed to be created with err
+func (a *Application) countStoreInitFailure(err error) {
+	metrics.SessionStoreInitFailures.With(prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"application":  a.proxyConfig.AssignedApplicationSlug,
+		"backend":      a.sessionBackend(),
+		"reason":       storeInitFailureReason(err),
+	}).Inc()
+}
+
+// observeStoreLatency records the latency of a session store operation
+func (a *Application) observeStoreLatency(op string, d time.Duration) {
+	metrics.SessionStoreTiming.With(prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"backend":      a.sessionBackend(),
+		"operation":    op,
+	}).Observe(d.Seconds())
+}
+
+// redisLatencyHook records the latency of every Redis command sent by the session store,
+// labeled by the command name to keep the cardinality low
+type redisLatencyHook struct {
+	a *Application
+}
+
+func (h redisLatencyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisLatencyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.a.observeStoreLatency(strings.ToLower(cmd.Name()), time.Since(start))
+		return err
+	}
+}
+
+func (h redisLatencyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.a.observeStoreLatency("pipeline", time.Since(start))
+		return err
+	}
+}
+
+// sessionCount returns the number of sessions in the session store, without decoding them
+func (a *Application) sessionCount(ctx context.Context) (int, error) {
+	count := 0
+	switch s := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		files, err := os.ReadDir(s.Path())
+		if err != nil {
+			return 0, err
+		}
+		for _, file := range files {
+			if s.IsSessionFile(file.Name()) {
+				count++
+			}
+		}
+	case *memorystore.MemoryStore:
+		s.Range(func(id string, values map[interface{}]interface{}) bool {
+			count++
+			return true
+		})
+	case *redisstore.RedisStore:
+		// SCAN may return a key more than once while the keyspace is rehashed,
+		// which is acceptable for a metric
+		err := s.Scan(ctx, func(keys []string) error {
+			count += len(keys)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	case *postgresstore.PostgresStore:
+		return s.Count(ctx)
+	case LogoutStore:
+		err := s.Walk(ctx, func(id string, values map[interface{}]interface{}) bool {
+			count++
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,199 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/metrics"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+func TestSessionCount(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+
+	count, err := a.sessionCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	for i := 0; i < 3; i++ {
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	}
+	count, err = a.sessionCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	assert.NoError(t, c.Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func TestDecodeErrorMetric(t *testing.T) {
+	dir := t.TempDir()
+	config.Get().Proxy.SessionDir = dir
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	counter := metrics.SessionDecodeErrors.With(prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"backend":      SessionBackendFilesystem,
+	})
+	before := counterValue(t, counter)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "session_foo"), []byte("undecodable"), 0600))
+	_, err := a.Sessions(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, before+1, counterValue(t, counter))
+
+	// Cookies which can't be decoded, for example after the cookie secret was rotated,
+	// are counted as well
+	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	req.AddCookie(&http.Cookie{Name: a.SessionName(), Value: "undecodable"})
+	assert.Nil(t, a.getClaimsFromSession(req))
+	assert.Equal(t, before+2, counterValue(t, counter))
+}
+
+func TestStoreInitFailureMetric(t *testing.T) {
+	a := newTestApplication(t)
+	defer a.Stop()
+	config.Get().Proxy.SessionBackend = "foo"
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	counter := metrics.SessionStoreInitFailures.With(prometheus.Labels{
+		"outpost_name": a.outpostName,
+		"application":  a.proxyConfig.AssignedApplicationSlug,
+		"backend":      "foo",
+		"reason":       "unsupported_backend",
+	})
+	before := counterValue(t, counter)
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	_, err := a.getStore(a.proxyConfig, u)
+	assert.ErrorIs(t, err, ErrUnsupportedBackend)
+	assert.Equal(t, before+1, counterValue(t, counter))
+
+	assert.Equal(t, "redis_unavailable", storeInitFailureReason(fmt.Errorf("%w: timeout", ErrRedisUnavailable)))
+	assert.Equal(t, "session_dir", storeInitFailureReason(ErrInvalidSessionInstance))
+	assert.Equal(t, "other", storeInitFailureReason(errors.New("foo")))
+}
+
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	m := &dto.Metric{}
+	assert.NoError(t, o.(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestStoreLatencyMetric(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	observer := func(op string) prometheus.Observer {
+		return metrics.SessionStoreTiming.With(prometheus.Labels{
+			"outpost_name": a.outpostName,
+			"backend":      SessionBackendFilesystem,
+			"operation":    op,
+		})
+	}
+	writes := sampleCount(t, observer("write"))
+	reads := sampleCount(t, observer("read"))
+	deletes := sampleCount(t, observer("delete"))
+
+	req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo"}
+	assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	assert.Equal(t, writes+1, sampleCount(t, observer("write")))
+
+	deleted, err := a.LogoutCount(context.Background(), func(c Claims) bool {
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Equal(t, reads+1, sampleCount(t, observer("read")))
+	assert.Equal(t, deletes+1, sampleCount(t, observer("delete")))
+}
+
+func TestSessionMetrics_Handover(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	store := a.sessionStore()
+	assert.Eventually(t, func() bool {
+		return a.ownsSessionMetrics(store)
+	}, time.Second, 10*time.Millisecond)
+
+	// The application replacing a on refresh counts the sessions of the store it took over
+	replaced, err := NewApplication(a.proxyConfig, http.DefaultClient, a.srv, a)
+	assert.NoError(t, err)
+	assert.Same(t, store, replaced.sessionStore())
+	assert.Eventually(t, func() bool {
+		return replaced.ownsSessionMetrics(store) && !a.ownsSessionMetrics(store)
+	}, time.Second, 10*time.Millisecond)
+	a.Stop()
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, replaced.ownsSessionMetrics(store))
+
+	// The gauge of an application which isn't replaced is removed once it is stopped
+	replaced.Stop()
+	assert.Eventually(t, func() bool {
+		_, ok := sessionMetricsOwners.Load(store)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, metrics.Sessions.Delete(replaced.sessionMetricsLabels()))
+}
+
+func TestUpdateSessionMetrics_SharedKeyPrefix(t *testing.T) {
+	defer func() {
+		config.Get().Proxy.Applications = nil
+	}()
+	a := newTestApplication(t, withSlug("foo"))
+	defer a.Stop()
+	// The store isn't connected, so counting its sessions fails
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	rs, _ := redisstore.NewRedisStore(context.Background(), client)
+	a.sessionsMutex.Lock()
+	a.sessions = rs
+	a.sessionsMutex.Unlock()
+	metrics.Sessions.With(a.sessionMetricsLabels()).Set(1)
+
+	// Sessions under the prefix shared by all applications aren't counted
+	a.updateSessionMetrics()
+	assert.False(t, metrics.Sessions.Delete(a.sessionMetricsLabels()))
+
+	// The gauge is kept when counting the sessions of the application fails
+	config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+		"foo": {SessionKeyPrefix: "authentik_foo_"},
+	}
+	metrics.Sessions.With(a.sessionMetricsLabels()).Set(1)
+	a.updateSessionMetrics()
+	assert.True(t, metrics.Sessions.Delete(a.sessionMetricsLabels()))
+}
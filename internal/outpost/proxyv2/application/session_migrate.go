@@ -0,0 +1,86 @@
+package application
+
+import (
+	"context"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// migrateFilesystemSessions copies the filesystem sessions of the application into rs, so
+// that switching from the filesystem backend to Redis doesn't log out users, and returns
+// the number of migrated sessions. Sessions are stored under their session ID with their
+// remaining lifetime, and their cookies are decoded with the cookie codecs of rs.
+// Expired sessions are skipped, as are sessions which are already stored in Redis, so
+// that migrating again doesn't overwrite sessions which changed since. Session files
+// are kept, so that the backend can be switched back.
+func (a *Application) migrateFilesystemSessions(ctx context.Context, rs *redisstore.RedisStore, opts sessions.Options) (int, error) {
+	store, err := a.getFilesystemStore(a.proxyConfig, opts.MaxAge, opts)
+	if err != nil {
+		return 0, err
+	}
+	fs := store.(*filesystemstore.FilesystemStore)
+	files, err := os.ReadDir(fs.Path())
+	if err != nil {
+		return 0, err
+	}
+	// Decode without checking the timestamp, expired sessions are skipped below
+	cs := append(cookieSecretCodecs(0, a.proxyConfig), a.getAllCodecs()...)
+	serializer := a.redisSerializer(rs.Client())
+	maxAge := time.Duration(opts.MaxAge) * time.Second
+	migrated := 0
+	for _, file := range files {
+		if !fs.IsSessionFile(file.Name()) {
+			continue
+		}
+		fullPath := path.Join(fs.Path(), file.Name())
+		id, _ := fs.SessionID(fullPath)
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		data, err := fs.ReadFile(fullPath)
+		if err != nil {
+			a.log.WithError(err).WithField("id", fullPath).Warning("failed to read session")
+			continue
+		}
+		s := &sessions.Session{ID: id, Values: map[interface{}]interface{}{}}
+		if err := a.decodeSessionFile(data, &s.Values, cs); err != nil {
+			a.countDecodeError()
+			a.log.WithError(err).WithField("id", fullPath).Debug("failed to decode session")
+			continue
+		}
+		var ttl time.Duration
+		if expires := sessionFileExpiry(info.ModTime(), maxAge, s.Values); !expires.IsZero() {
+			if sessionExpired(expires) {
+				continue
+			}
+			ttl = time.Until(expires.Add(max(config.Get().Proxy.SessionClockSkew, 0)))
+		}
+		s.Options = &sessions.Options{MaxAge: int(math.Ceil(ttl.Seconds()))}
+		b, err := serializer.Serialize(s)
+		if err != nil {
+			a.log.WithError(err).WithField("id", fullPath).Warning("failed to serialize session")
+			continue
+		}
+		var set bool
+		err = withRedisRetry(ctx, func(ctx context.Context) error {
+			var err error
+			set, err = rs.Client().SetNX(ctx, a.redisKeyPrefix()+id, b, ttl).Result()
+			return err
+		})
+		if err != nil {
+			return migrated, err
+		}
+		if set {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
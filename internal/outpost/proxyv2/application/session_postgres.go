@@ -0,0 +1,140 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+)
+
+// defaultPostgresTimeout is the timeout of connecting to PostgreSQL and of the queries
+// issued by the session cleanup
+const defaultPostgresTimeout = 5 * time.Second
+
+// postgresPool is a connection pool shared by the session stores of all applications
+// connecting to the same database, so that every application doesn't open connections of
+// its own to the database of authentik
+type postgresPool struct {
+	pool *pgxpool.Pool
+	refs int
+}
+
+var (
+	postgresPoolsMutex sync.Mutex
+	postgresPools      = map[string]*postgresPool{}
+)
+
+func (a *Application) getPostgresStore(opts sessions.Options) (sessions.Store, error) {
+	pool, err := acquirePostgresPool(postgresConnString(config.Get().PostgreSQL))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultPostgresTimeout)
+	defer cancel()
+	ps, err := postgresstore.NewPostgresStore(ctx, pool)
+	if err == nil {
+		err = ps.CreateTable(ctx)
+	}
+	if err != nil {
+		releasePostgresPool(pool)
+		return nil, fmt.Errorf("%w: %w", ErrPostgresUnavailable, err)
+	}
+	ps.Namespace(a.postgresNamespace())
+	ps.Options(opts)
+	ps.Serializer(getSessionSerializer())
+	ps.MaxLength(config.Get().Proxy.SessionMaxLength)
+	return ps, nil
+}
+
+// postgresNamespace returns the namespace of this application's sessions in PostgreSQL,
+// which is the slug of the application after the configured session key prefix, so that
+// outposts sharing the database can still keep their sessions apart. Slugs can't contain the
+// separator, so namespaces of different prefixes and slugs never collide.
+func (a *Application) postgresNamespace() string {
+	return a.sessionKeyPrefix() + ":" + a.proxyConfig.AssignedApplicationSlug
+}
+
+// postgresConnString returns the connection string of the database in the keyword/value
+// format of libpq, so that the TLS settings have the same meaning as for authentik
+func postgresConnString(pc config.PostgreSQLConfig) string {
+	params := []string{}
+	add := func(key string, value string) {
+		if value == "" {
+			return
+		}
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `'`, `\'`)
+		params = append(params, fmt.Sprintf("%s='%s'", key, value))
+	}
+	add("host", pc.Host)
+	if pc.Port != 0 {
+		add("port", strconv.Itoa(pc.Port))
+	}
+	add("dbname", pc.Name)
+	add("user", pc.User)
+	add("password", pc.Password)
+	add("sslmode", pc.SSLMode)
+	add("sslrootcert", pc.SSLRootCert)
+	add("sslcert", pc.SSLCert)
+	add("sslkey", pc.SSLKey)
+	add("search_path", pc.DefaultSchema)
+	return strings.Join(params, " ")
+}
+
+// acquirePostgresPool returns the connection pool of the database with the given
+// connection string, creating it when no application is connected to the database yet.
+// Connections are opened when they are first used.
+func acquirePostgresPool(connString string) (*pgxpool.Pool, error) {
+	postgresPoolsMutex.Lock()
+	defer postgresPoolsMutex.Unlock()
+	if p, ok := postgresPools[connString]; ok {
+		p.refs++
+		return p.pool, nil
+	}
+	pool, err := pgxpool.New(context.Background(), connString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPostgresConfig, err)
+	}
+	postgresPools[connString] = &postgresPool{pool: pool, refs: 1}
+	return pool, nil
+}
+
+// releasePostgresPool releases a pool returned by acquirePostgresPool, which is closed
+// once no application uses it anymore
+func releasePostgresPool(pool *pgxpool.Pool) {
+	postgresPoolsMutex.Lock()
+	defer postgresPoolsMutex.Unlock()
+	for connString, p := range postgresPools {
+		if p.pool != pool {
+			continue
+		}
+		p.refs--
+		if p.refs <= 0 {
+			delete(postgresPools, connString)
+			pool.Close()
+		}
+		return
+	}
+}
+
+// cleanupPostgresSessions deletes the expired sessions in PostgreSQL, including those of
+// other applications, which only uses the index on the expiry of sessions
+func (a *Application) cleanupPostgresSessions(store *postgresstore.PostgresStore) int {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultPostgresTimeout)
+	defer cancel()
+	deleted, err := store.DeleteExpired(ctx)
+	if err != nil {
+		a.log.WithError(err).Warning("failed to delete expired sessions")
+	}
+	if deleted > 0 {
+		a.log.WithField("deleted", deleted).Debug("deleted expired sessions")
+	}
+	return deleted
+}
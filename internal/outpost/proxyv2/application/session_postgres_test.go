@@ -0,0 +1,122 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+)
+
+func TestPostgresConnString(t *testing.T) {
+	assert.Equal(t, "", postgresConnString(config.PostgreSQLConfig{}))
+	assert.Equal(t,
+		`host='db' port='5432' dbname='authentik' user='authentik' password='it\'s\\secret' sslmode='verify-full' search_path='public'`,
+		postgresConnString(config.PostgreSQLConfig{
+			Host:          "db",
+			Port:          5432,
+			Name:          "authentik",
+			User:          "authentik",
+			Password:      `it's\secret`,
+			SSLMode:       "verify-full",
+			DefaultSchema: "public",
+		}),
+	)
+}
+
+func TestPostgresNamespace(t *testing.T) {
+	defer func() {
+		config.Get().Proxy.SessionKeyPrefix = ""
+	}()
+	assert.Equal(t, ":foo", newTestApplication(t, withSlug("foo")).postgresNamespace())
+	config.Get().Proxy.SessionKeyPrefix = "outpost_"
+	assert.Equal(t, "outpost_:foo", newTestApplication(t, withSlug("foo")).postgresNamespace())
+}
+
+func TestAcquirePostgresPool(t *testing.T) {
+	// Pools are shared by their connection string and closed once they are released by all users
+	pool, err := acquirePostgresPool("host=localhost port=1")
+	assert.NoError(t, err)
+	shared, err := acquirePostgresPool("host=localhost port=1")
+	assert.NoError(t, err)
+	assert.Same(t, pool, shared)
+	releasePostgresPool(pool)
+	assert.Contains(t, postgresPools, "host=localhost port=1")
+	releasePostgresPool(shared)
+	assert.NotContains(t, postgresPools, "host=localhost port=1")
+
+	_, err = acquirePostgresPool("port=invalid")
+	assert.ErrorIs(t, err, ErrInvalidPostgresConfig)
+}
+
+func TestGetStore_PostgresUnavailable(t *testing.T) {
+	a := newTestApplication(t)
+	config.Get().Proxy.SessionBackend = SessionBackendPostgres
+	pc := config.Get().PostgreSQL
+	config.Get().PostgreSQL = config.PostgreSQLConfig{Host: "127.0.0.1", Port: 1}
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+		config.Get().PostgreSQL = pc
+	}()
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	_, err := a.getStore(a.proxyConfig, u)
+	assert.ErrorIs(t, err, ErrPostgresUnavailable)
+	assert.Equal(t, "postgres_unavailable", storeInitFailureReason(err))
+	// The pool of the failed store is closed
+	assert.NotContains(t, postgresPools, postgresConnString(config.Get().PostgreSQL))
+}
+
+func TestLogout_Postgres(t *testing.T) {
+	config.Get().Proxy.SessionKeyPrefix = "authentik_proxy_test_" + uuid.NewString() + "_"
+	defer func() {
+		config.Get().Proxy.SessionKeyPrefix = ""
+	}()
+	a := newTestApplication(t, withSlug("foo"))
+	defer a.Stop()
+	useTestPostgresStore(t, a)
+	assert.IsType(t, &postgresstore.PostgresStore{}, a.sessions)
+	assert.NoError(t, a.Healthy(context.Background()))
+
+	cookies := map[string]*http.Cookie{}
+	for _, sub := range []string{"foo", "bar"} {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = Claims{Sub: sub, Exp: int(time.Now().Add(time.Hour).Unix())}
+		rr := httptest.NewRecorder()
+		assert.NoError(t, a.sessions.Save(req, rr, s))
+		cookies[sub] = rr.Result().Cookies()[0]
+	}
+	claims := func(sub string) *Claims {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		req.AddCookie(cookies[sub])
+		return a.getClaimsFromSession(req)
+	}
+	assert.Equal(t, "foo", claims("foo").Sub)
+	count, err := a.sessionCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	deleted, err := a.LogoutCount(context.Background(), func(c Claims) bool {
+		return c.Sub == "foo"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.Nil(t, claims("foo"))
+	assert.NotNil(t, claims("bar"))
+
+	deleted, err = a.LogoutAll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	all, err := a.Sessions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, all, 0)
+	a.cleanupSessions()
+}
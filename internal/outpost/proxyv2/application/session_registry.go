@@ -0,0 +1,85 @@
+package application
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/api/v3"
+)
+
+// SessionStoreConfig is passed to the factory of the session store of an application
+type SessionStoreConfig struct {
+	// Application whose sessions are stored
+	Application *Application
+	// Provider configuration of the application
+	Provider api.ProxyOutpostConfig
+	// Options of new sessions, the max age is the maximum lifetime of sessions in seconds
+	Options sessions.Options
+}
+
+// SessionStoreFactory creates the session store of an application
+type SessionStoreFactory func(cfg SessionStoreConfig) (sessions.Store, error)
+
+// LogoutStore is implemented by custom session stores, so that their sessions can be
+// logged out, listed and counted. Stores which don't implement it can only store sessions.
+type LogoutStore interface {
+	sessions.Store
+	// Walk calls fn with the ID and values of every session in the store until fn
+	// returns false. Sessions may be deleted while they are walked.
+	Walk(ctx context.Context, fn func(id string, values map[interface{}]interface{}) bool) error
+	// Delete deletes the session with the given ID, deleting a session which doesn't
+	// exist is not an error
+	Delete(ctx context.Context, id string) error
+}
+
+var (
+	sessionStoresMutex sync.RWMutex
+	sessionStores      = map[string]SessionStoreFactory{}
+)
+
+func init() {
+	RegisterSessionStore(SessionBackendMemory, func(cfg SessionStoreConfig) (sessions.Store, error) {
+		return newMemoryStore(cfg.Options), nil
+	})
+	RegisterSessionStore(SessionBackendRedis, func(cfg SessionStoreConfig) (sessions.Store, error) {
+		return cfg.Application.getRedisStore(cfg.Options)
+	})
+	RegisterSessionStore(SessionBackendFilesystem, func(cfg SessionStoreConfig) (sessions.Store, error) {
+		return cfg.Application.getFilesystemStore(cfg.Provider, cfg.Options.MaxAge, cfg.Options)
+	})
+	RegisterSessionStore(SessionBackendPostgres, func(cfg SessionStoreConfig) (sessions.Store, error) {
+		return cfg.Application.getPostgresStore(cfg.Options)
+	})
+}
+
+// RegisterSessionStore registers the factory of the session backend with the given name,
+// which can then be selected with the session backend setting. Registering a backend with
+// the name of an existing backend replaces it. Stores should implement LogoutStore.
+func RegisterSessionStore(name string, factory SessionStoreFactory) {
+	sessionStoresMutex.Lock()
+	defer sessionStoresMutex.Unlock()
+	sessionStores[strings.ToLower(name)] = factory
+}
+
+// sessionStoreFactory returns the factory of the session backend with the given name
+func sessionStoreFactory(name string) (SessionStoreFactory, bool) {
+	sessionStoresMutex.RLock()
+	defer sessionStoresMutex.RUnlock()
+	factory, ok := sessionStores[name]
+	return factory, ok
+}
+
+// sessionStoreNames returns the names of all registered session backends, sorted
+func sessionStoreNames() []string {
+	sessionStoresMutex.RLock()
+	defer sessionStoresMutex.RUnlock()
+	names := make([]string, 0, len(sessionStores))
+	for name := range sessionStores {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
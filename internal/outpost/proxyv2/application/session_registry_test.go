@@ -0,0 +1,79 @@
+package application
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+)
+
+// customStore is a session store registered like a third party backend
+type customStore struct {
+	*memorystore.MemoryStore
+}
+
+func (cs customStore) Walk(ctx context.Context, fn func(id string, values map[interface{}]interface{}) bool) error {
+	cs.Range(fn)
+	return nil
+}
+
+func (cs customStore) Delete(ctx context.Context, id string) error {
+	cs.MemoryStore.Delete(id)
+	return nil
+}
+
+func TestRegisterSessionStore(t *testing.T) {
+	var cfg SessionStoreConfig
+	RegisterSessionStore("Custom", func(c SessionStoreConfig) (sessions.Store, error) {
+		cfg = c
+		return customStore{newMemoryStore(c.Options)}, nil
+	})
+	defer func() {
+		sessionStoresMutex.Lock()
+		delete(sessionStores, "custom")
+		sessionStoresMutex.Unlock()
+	}()
+	config.Get().Proxy.SessionBackend = "custom"
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	assert.IsType(t, customStore{}, a.sessions)
+	assert.Equal(t, a, cfg.Application)
+	assert.Equal(t, "custom", a.EffectiveSessionBackend())
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	for _, sub := range []string{"foo", "foo", "bar"} {
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = Claims{Sub: sub}
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	}
+	// Logouts, listing and counting use the extension point of the store
+	deleted, err := a.LogoutUser(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	all, err := a.Sessions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+	count, err := a.sessionCount(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	deleted, err = a.LogoutAll(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	// Unknown backends list the registered backends
+	config.Get().Proxy.SessionBackend = "foo"
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	_, err = a.getStore(a.proxyConfig, u)
+	assert.ErrorIs(t, err, ErrUnsupportedBackend)
+	assert.ErrorContains(t, err, "must be one of custom, filesystem, memory, postgres, redis")
+}
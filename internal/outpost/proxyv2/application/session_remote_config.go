@@ -0,0 +1,42 @@
+package application
+
+const (
+	// outpostConfigSessionKeyPrefix is the key of the outpost configuration in authentik
+	// which overrides the local Redis key prefix of sessions
+	outpostConfigSessionKeyPrefix = "proxy_session_key_prefix"
+	// outpostConfigSessionRedisDB is the key of the outpost configuration in authentik
+	// which overrides the local Redis database of sessions
+	outpostConfigSessionRedisDB = "proxy_session_redis_db"
+)
+
+// outpostConfig returns the configuration of the outpost managed in authentik
+func (a *Application) outpostConfig() map[string]interface{} {
+	if a.srv == nil || a.srv.API() == nil {
+		return nil
+	}
+	return a.srv.API().Outpost.Config
+}
+
+// remoteSessionKeyPrefix returns the Redis key prefix of sessions set in the outpost
+// configuration in authentik, and whether it is set
+func (a *Application) remoteSessionKeyPrefix() (string, bool) {
+	prefix, ok := a.outpostConfig()[outpostConfigSessionKeyPrefix].(string)
+	return prefix, ok && prefix != ""
+}
+
+// remoteSessionRedisDB returns the Redis database of sessions set in the outpost
+// configuration in authentik, and whether it is set. Numbers are decoded from JSON as
+// floats, fractional and negative databases are ignored.
+func (a *Application) remoteSessionRedisDB() (int, bool) {
+	switch db := a.outpostConfig()[outpostConfigSessionRedisDB].(type) {
+	case float64:
+		if db < 0 || db != float64(int(db)) {
+			a.log.WithField("db", db).Warning("invalid session redis db in outpost config, ignoring")
+			return 0, false
+		}
+		return int(db), true
+	case int:
+		return db, db >= 0
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+package application
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+)
+
+func TestRemoteSessionKeyPrefix(t *testing.T) {
+	a := newTestApplication(t, withSlug("foo"))
+	config.Get().Proxy.SessionKeyPrefix = "authentik_local_session_"
+	defer func() {
+		config.Get().Proxy.SessionKeyPrefix = ""
+		config.Get().Proxy.Applications = nil
+	}()
+	assert.Equal(t, "authentik_local_session_", a.redisKeyPrefix())
+
+	// The prefix set in authentik replaces the local prefix of the outpost
+	a.srv.API().Outpost.Config[outpostConfigSessionKeyPrefix] = "authentik_remote_session_"
+	assert.Equal(t, "authentik_remote_session_", a.redisKeyPrefix())
+	a.srv.API().Outpost.Config[outpostConfigSessionKeyPrefix] = nil
+	assert.Equal(t, "authentik_local_session_", a.redisKeyPrefix())
+
+	// Local prefixes of the application take precedence
+	a.srv.API().Outpost.Config[outpostConfigSessionKeyPrefix] = "authentik_remote_session_"
+	config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+		"foo": {SessionKeyPrefix: "authentik_foo_session_"},
+	}
+	assert.Equal(t, "authentik_foo_session_", a.redisKeyPrefix())
+}
+
+func TestRemoteSessionRedisDB(t *testing.T) {
+	a := newTestApplication(t, withSlug("foo"))
+	config.Get().Redis.DB = 1
+	defer func() {
+		config.Get().Redis.DB = 0
+		config.Get().Proxy.Applications = nil
+	}()
+	// Numbers are decoded from JSON as floats
+	a.srv.API().Outpost.Config[outpostConfigSessionRedisDB] = float64(2)
+	assert.Equal(t, 2, a.redisDB())
+	for _, invalid := range []interface{}{float64(-1), 1.5, "3"} {
+		a.srv.API().Outpost.Config[outpostConfigSessionRedisDB] = invalid
+		assert.Equal(t, 1, a.redisDB())
+	}
+
+	// Local databases of the application and dedicated Redis servers take precedence
+	a.srv.API().Outpost.Config[outpostConfigSessionRedisDB] = float64(2)
+	config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+		"foo": {SessionRedis: &config.RedisConfig{DB: 4}},
+	}
+	assert.Equal(t, 4, a.redisDB())
+	db := 3
+	config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+		"foo": {SessionRedisDB: &db},
+	}
+	assert.Equal(t, 3, a.redisDB())
+}
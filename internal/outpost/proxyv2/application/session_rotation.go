@@ -0,0 +1,72 @@
+package application
+
+import (
+	"net/http"
+	"time"
+
+	"goauthentik.io/internal/config"
+)
+
+const (
+	// sessionIDIssuedAt is the session value holding the Unix time at which the session
+	// ID was issued, sessions without it were issued when the session was created
+	sessionIDIssuedAt = "ak_id_issued_at"
+	// sessionPreviousID is the session value holding the ID the session had before it was
+	// rotated, until the session is deleted under that ID
+	sessionPreviousID = "ak_previous_id"
+)
+
+func (a *Application) sessionIDRotationInterval() time.Duration {
+	return config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionIDRotationInterval
+}
+
+// rotateSessionID issues a new ID for the session of r with claims c once its ID is older
+// than the rotation interval, so that a stolen session cookie is only useful until the
+// next rotation. The session is kept under its previous ID until the first request with
+// the new ID, so that concurrent requests still sent with the previous cookie succeed.
+func (a *Application) rotateSessionID(rw http.ResponseWriter, r *http.Request, c *Claims) {
+	interval := a.sessionIDRotationInterval()
+	if interval <= 0 {
+		return
+	}
+	s, err := a.getSession(r, a.SessionName())
+	if err != nil || s.IsNew || s.ID == "" {
+		return
+	}
+	// Rotating doesn't extend the session
+	maxAge := a.sessionMaxAge(*c)
+	if maxAge <= 0 {
+		return
+	}
+	s.Options.MaxAge = maxAge
+	if previousID, ok := s.Values[sessionPreviousID].(string); ok {
+		if err := a.LogoutSession(r.Context(), previousID); err != nil {
+			a.log.WithError(err).Warning("failed to delete session from before rotation")
+			return
+		}
+		delete(s.Values, sessionPreviousID)
+		if err := s.Save(r, rw); err != nil {
+			a.log.WithError(err).Warning("failed to save rotated session")
+		}
+		return
+	}
+	issuedAt, _ := s.Values[sessionIDIssuedAt].(int64)
+	if issuedAt == 0 {
+		issuedAt = c.CreatedAt
+	}
+	if time.Since(time.Unix(issuedAt, 0)) < interval {
+		return
+	}
+	previousID := s.ID
+	s.ID = a.taggedSessionID(renewedSessionID(previousID), *c)
+	s.Values[sessionIDIssuedAt] = time.Now().Unix()
+	s.Values[sessionPreviousID] = previousID
+	if err := s.Save(r, rw); err != nil {
+		a.log.WithError(err).Warning("failed to rotate session ID")
+		s.ID = previousID
+		delete(s.Values, sessionIDIssuedAt)
+		delete(s.Values, sessionPreviousID)
+		return
+	}
+	a.log.Trace("rotated session ID")
+}
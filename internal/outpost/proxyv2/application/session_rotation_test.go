@@ -0,0 +1,63 @@
+package application
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+func TestRotateSessionID(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionIDRotationInterval = time.Hour
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionIDRotationInterval = 0
+	}()
+	a := newTestApplication(t)
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	claims := Claims{
+		Sub:       "foo",
+		Exp:       int(time.Now().Add(24 * time.Hour).Unix()),
+		CreatedAt: time.Now().Unix(),
+	}
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = claims
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	cookie := rr.Result().Cookies()[0]
+	id := s.ID
+
+	request := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		c, err := a.checkAuth(rr, req)
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", c.Sub)
+		return rr
+	}
+
+	// Sessions are kept until the interval passed
+	assert.Empty(t, request(cookie).Result().Cookies())
+
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo", Exp: claims.Exp, CreatedAt: time.Now().Add(-2 * time.Hour).Unix()}
+	assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	cookies := request(cookie).Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.NotEqual(t, cookie.Value, cookies[0].Value)
+	// The previous session stays valid until the new session is used
+	assert.FileExists(t, store.Filename(id))
+
+	request(cookies[0])
+	assert.NoFileExists(t, store.Filename(id))
+	// The new session isn't rotated again
+	assert.Empty(t, request(cookies[0]).Result().Cookies())
+}
@@ -0,0 +1,108 @@
+package application
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	log "github.com/sirupsen/logrus"
+)
+
+// selfTestKey is the session value written by SelfTest
+const selfTestKey = "ak_proxy_self_test"
+
+// SelfTestResult holds the latency of each step of a session store self-test
+type SelfTestResult struct {
+	Write  time.Duration
+	Read   time.Duration
+	Delete time.Duration
+}
+
+// cookieRecorder is a http.ResponseWriter which only records the headers written to it,
+// to capture the session cookie set by the session store
+type cookieRecorder struct {
+	header http.Header
+}
+
+func (cr *cookieRecorder) Header() http.Header         { return cr.header }
+func (cr *cookieRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (cr *cookieRecorder) WriteHeader(int)             {}
+
+// request returns a request carrying the cookies recorded so far
+func (cr *cookieRecorder) request(ctx context.Context) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range (&http.Response{Header: cr.header}).Cookies() {
+		r.AddCookie(c)
+	}
+	return r, nil
+}
+
+// SelfTest writes a dummy session to the session store, reads it back, verifies that it
+// is unchanged and deletes it, so that connectivity, TLS, authentication or serialization
+// problems of the session backend surface before the first user logs in.
+func (a *Application) SelfTest(ctx context.Context) (SelfTestResult, error) {
+	result := SelfTestResult{}
+	value := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	rec := &cookieRecorder{header: http.Header{}}
+
+	r, err := rec.request(ctx)
+	if err != nil {
+		return result, err
+	}
+	start := time.Now()
+	s, err := a.sessionStore().New(r, a.SessionName())
+	if err != nil {
+		return result, fmt.Errorf("failed to create session: %w", err)
+	}
+	s.Options.MaxAge = int(time.Minute.Seconds())
+	s.Values[selfTestKey] = value
+	if err := s.Save(r, rec); err != nil {
+		return result, fmt.Errorf("failed to write session: %w", err)
+	}
+	result.Write = time.Since(start)
+
+	r, err = rec.request(ctx)
+	if err != nil {
+		return result, err
+	}
+	start = time.Now()
+	loaded, err := a.sessionStore().New(r, a.SessionName())
+	if err != nil {
+		return result, fmt.Errorf("failed to read session: %w", err)
+	}
+	result.Read = time.Since(start)
+	if loaded.IsNew || loaded.Values[selfTestKey] != value {
+		return result, errors.New("session read back differs from the session written")
+	}
+
+	start = time.Now()
+	loaded.Options.MaxAge = -1
+	if err := loaded.Save(r, rec); err != nil {
+		return result, fmt.Errorf("failed to delete session: %w", err)
+	}
+	result.Delete = time.Since(start)
+	return result, nil
+}
+
+// runSelfTest runs SelfTest and logs its result
+func (a *Application) runSelfTest() {
+	result, err := a.SelfTest(context.Background())
+	l := a.log.WithFields(log.Fields{
+		"backend": a.sessionBackend(),
+		"write":   result.Write.String(),
+		"read":    result.Read.String(),
+		"delete":  result.Delete.String(),
+	})
+	if err != nil {
+		l.WithError(err).Warning("session store self-test failed")
+		return
+	}
+	l.Info("session store self-test succeeded")
+}
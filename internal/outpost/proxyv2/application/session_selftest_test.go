@@ -0,0 +1,44 @@
+package application
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+)
+
+func TestSelfTest(t *testing.T) {
+	for _, backend := range []string{SessionBackendFilesystem, SessionBackendMemory} {
+		t.Run(backend, func(t *testing.T) {
+			config.Get().Proxy.SessionBackend = backend
+			config.Get().Proxy.SessionDir = t.TempDir()
+			defer func() {
+				config.Get().Proxy.SessionBackend = ""
+				config.Get().Proxy.SessionDir = ""
+			}()
+			a := newTestApplication(t)
+			result, err := a.SelfTest(context.Background())
+			assert.NoError(t, err)
+			assert.Greater(t, result.Write, time.Duration(0))
+
+			// The dummy session is deleted again
+			count, err := a.sessionCount(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 0, count)
+		})
+	}
+}
+
+func TestSelfTest_Failure(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	assert.NoError(t, os.RemoveAll(config.Get().Proxy.SessionDir))
+	_, err := a.SelfTest(context.Background())
+	assert.Error(t, err)
+}
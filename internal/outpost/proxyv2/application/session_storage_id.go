@@ -0,0 +1,45 @@
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+
+	"goauthentik.io/internal/config"
+)
+
+// storageID returns the ID under which the session with the given ID is stored. When
+// session ID hashing is enabled, that is an HMAC of the ID with the cookie secret, so that
+// Redis keys and session file names can't be used as session cookies. The tenant and tags
+// of the ID are kept in front of the HMAC, so that sweeps can still be limited by them.
+func (a *Application) storageID(id string) string {
+	if !config.Get().Proxy.SessionIDHashing {
+		return id
+	}
+	return hashedStorageID(id, a.proxyConfig.GetCookieSecret())
+}
+
+// previousStorageIDs returns the IDs under which the session with the given ID was stored
+// with the previous cookie secrets of the application, so that sessions created before
+// the cookie secret was rotated are still found and moved to their current ID
+func (a *Application) previousStorageIDs(id string) []string {
+	if !config.Get().Proxy.SessionIDHashing {
+		return nil
+	}
+	ids := []string{}
+	for _, secret := range cookieSecrets(a.proxyConfig)[1:] {
+		ids = append(ids, hashedStorageID(id, secret))
+	}
+	return ids
+}
+
+// hashedStorageID returns the storage ID of the session with the given ID hashed with secret
+func hashedStorageID(id string, secret string) string {
+	prefix := sessionIDTenantPrefix(id)
+	if tags, _, ok := strings.Cut(id[len(prefix):], sessionTagSeparator); ok {
+		prefix += tags + sessionTagSeparator
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return prefix + base32RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package application
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/api/v3"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+func TestStorageID(t *testing.T) {
+	a := newTestApplication(t)
+	assert.Equal(t, "foo.bar", a.storageID("foo.bar"))
+
+	config.Get().Proxy.SessionIDHashing = true
+	defer func() {
+		config.Get().Proxy.SessionIDHashing = false
+	}()
+	hashed := a.storageID("foo.bar")
+	assert.NotContains(t, hashed, "bar")
+	assert.True(t, strings.HasPrefix(hashed, "foo."))
+	assert.Equal(t, hashed, a.storageID("foo.bar"))
+	assert.NotEqual(t, hashed, a.storageID("foo.baz"))
+	// Tags are kept, so that sweeps can skip the sessions of other users
+	assert.True(t, strings.HasPrefix(a.storageID("foo.AAAAAABBBBBB-bar"), "foo.AAAAAABBBBBB-"))
+}
+
+func TestStorageID_Filesystem(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionIDHashing = true
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionIDHashing = false
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo"}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+
+	// The file name doesn't reveal the session ID, which is only sent in the cookie
+	_, err := os.Stat(store.Filename(s.ID))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(store.Filename(a.storageID(s.ID)))
+	assert.NoError(t, err)
+	request := func() (Claims, bool) {
+		r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		r.AddCookie(rr.Result().Cookies()[0])
+		return a.ClaimsFromRequest(r)
+	}
+	c, ok := request()
+	assert.True(t, ok)
+	assert.Equal(t, "foo", c.Sub)
+
+	// Logouts delete the sessions by their hashed file names
+	deleted, err := a.LogoutUser(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, ok = request()
+	assert.False(t, ok)
+}
+
+func TestStorageID_CookieSecretRotation(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionIDHashing = true
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionIDHashing = false
+		config.Get().Proxy.PreviousCookieSecrets = nil
+	}()
+	a := newTestApplication(t)
+	defer a.Stop()
+
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, a.SessionName())
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo"}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	previous := a.sessions.(*filesystemstore.FilesystemStore).Filename(a.storageID(s.ID))
+
+	// Rotate the cookie secret
+	oldSecret := *a.proxyConfig.CookieSecret
+	config.Get().Proxy.PreviousCookieSecrets = []string{oldSecret}
+	a.proxyConfig.CookieSecret = api.PtrString("new-secret")
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	store, err := a.getStore(a.proxyConfig, u)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hashedStorageID(s.ID, oldSecret), a.storageID(s.ID))
+
+	// Sessions hashed with a previous secret are found and moved to their current ID
+	r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	r.AddCookie(rr.Result().Cookies()[0])
+	loaded, err := store.New(r, a.SessionName())
+	assert.NoError(t, err)
+	assert.False(t, loaded.IsNew)
+	assert.Equal(t, "foo", loaded.Values[constants.SessionClaims].(Claims).Sub)
+	_, err = os.Stat(previous)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(store.(*filesystemstore.FilesystemStore).Filename(a.storageID(s.ID)))
+	assert.NoError(t, err)
+}
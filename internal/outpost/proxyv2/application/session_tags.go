@@ -0,0 +1,112 @@
+package application
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+const (
+	// sessionTagLength is the length of each tag in a tagged session ID
+	sessionTagLength = 6
+	// sessionTagSeparator separates the tags from the random part of tagged session IDs
+	sessionTagSeparator = "-"
+
+	sessionTagSubject = "sub"
+	sessionTagSID     = "sid"
+)
+
+// sessionScope limits a sweep over the sessions of the store
+type sessionScope struct {
+	// tenant whose sessions are included, sessions of all tenants are included when empty
+	tenant string
+	// when tags is set, tagged filesystem sessions are skipped unless their tag of kind
+	// tagKind is one of tags
+	tagKind string
+	tags    map[string]struct{}
+}
+
+// inScope returns whether the session with the given key, as passed to walkSessions, is
+// in scope. Sessions without tags are always in scope, as they have to be decoded.
+func (a *Application) inScope(key string, scope sessionScope) bool {
+	if scope.tenant != "" && !a.inTenant(key, scope.tenant) {
+		return false
+	}
+	if scope.tags == nil {
+		return true
+	}
+	sub, sid, ok := a.sessionTags(key)
+	if !ok {
+		return true
+	}
+	tag := sub
+	if scope.tagKind == sessionTagSID {
+		tag = sid
+	}
+	_, ok = scope.tags[tag]
+	return ok
+}
+
+// tagScope returns a scope which skips the tagged sessions whose tag of kind doesn't match
+// value. Session files are tagged with any cookie secret of the applications sharing the
+// session directory, so the tags of all of them are included.
+func (a *Application) tagScope(kind string, value string) sessionScope {
+	scope := sessionScope{
+		tagKind: kind,
+		tags:    map[string]struct{}{},
+	}
+	for _, app := range append([]*Application{a}, a.srv.Apps()...) {
+		for _, secret := range cookieSecrets(app.proxyConfig) {
+			scope.tags[sessionTag(secret, kind, value)] = struct{}{}
+		}
+	}
+	return scope
+}
+
+// sessionTag returns a short HMAC of value, which doesn't reveal value without the secret
+func sessionTag(secret string, kind string, value string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(kind + ":" + value))
+	return base32RawStdEncoding.EncodeToString(mac.Sum(nil))[:sessionTagLength]
+}
+
+// taggedSessionID returns a new session ID for the session with the given ID which is
+// tagged with the subject and session ID of c, keeping the tenant of the ID, so that
+// logouts of a user can skip the session files of other users without reading them.
+// Only filesystem sessions are tagged when enabled, otherwise id is returned unchanged.
+func (a *Application) taggedSessionID(id string, c Claims) string {
+	if _, ok := a.sessionStore().(*filesystemstore.FilesystemStore); !ok || !config.Get().Proxy.SessionFilenameTags {
+		return id
+	}
+	secret := a.proxyConfig.GetCookieSecret()
+	return sessionIDTenantPrefix(id) +
+		sessionTag(secret, sessionTagSubject, c.Sub) +
+		sessionTag(secret, sessionTagSID, c.Sid) +
+		sessionTagSeparator +
+		base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+// sessionTags returns the subject and session ID tags of the filesystem session with the
+// given key, and whether the session is tagged
+func (a *Application) sessionTags(key string) (string, string, bool) {
+	store, ok := a.sessionStore().(*filesystemstore.FilesystemStore)
+	if !ok {
+		return "", "", false
+	}
+	id, ok := store.SessionID(key)
+	if !ok {
+		return "", "", false
+	}
+	if i := strings.LastIndex(id, sessionTenantSeparator); i >= 0 {
+		id = id[i+len(sessionTenantSeparator):]
+	}
+	tags, _, ok := strings.Cut(id, sessionTagSeparator)
+	if !ok || len(tags) != 2*sessionTagLength {
+		return "", "", false
+	}
+	return tags[:sessionTagLength], tags[sessionTagLength:], true
+}
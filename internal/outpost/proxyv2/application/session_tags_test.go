@@ -0,0 +1,73 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+func TestTaggedSessionID(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionFilenameTags = false
+	}()
+	a := newTestApplication(t)
+	c := Claims{Sub: "foo", Sid: "bar"}
+	assert.Equal(t, "tenant.id", a.taggedSessionID("tenant.id", c))
+
+	config.Get().Proxy.SessionFilenameTags = true
+	id := a.taggedSessionID("tenant.id", c)
+	assert.True(t, strings.HasPrefix(id, "tenant."))
+	sub, sid, ok := a.sessionTags(a.sessionKey(id))
+	assert.True(t, ok)
+	assert.Equal(t, sessionTag(a.proxyConfig.GetCookieSecret(), sessionTagSubject, "foo"), sub)
+	assert.Equal(t, sessionTag(a.proxyConfig.GetCookieSecret(), sessionTagSID, "bar"), sid)
+
+	_, _, ok = a.sessionTags(a.sessionKey("id"))
+	assert.False(t, ok)
+}
+
+func TestLogoutUser_Tags(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.SessionFilenameTags = true
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.SessionFilenameTags = false
+	}()
+	a := newTestApplication(t)
+	results := []LogoutResult{}
+	a.OnLogout(func(r LogoutResult) {
+		results = append(results, r)
+	})
+
+	save := func(id string, c Claims) {
+		req, _ := http.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.ID = id
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = c
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	}
+	save(a.taggedSessionID("", Claims{Sub: "foo"}), Claims{Sub: "foo"})
+	// Sessions without tags are still decoded
+	save("untagged", Claims{Sub: "foo"})
+	// Files tagged for another user aren't read
+	other := a.taggedSessionID("", Claims{Sub: "bar"})
+	store := a.sessions.(*filesystemstore.FilesystemStore)
+	assert.NoError(t, os.WriteFile(store.Filename(other), []byte("undecodable"), 0600))
+
+	deleted, err := a.LogoutUser(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 0, results[0].Undecodable)
+	assert.FileExists(t, store.Filename(other))
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"goauthentik.io/internal/config"
+)
+
+// sessionTenantSeparator separates the tenant from the random part of session IDs.
+// Generated IDs are base32 encoded, so they never contain it.
+const sessionTenantSeparator = "."
+
+// tenantFromHost returns the tenant of host for a wildcard-hosted application below
+// domain, which is the part of host before the domain. Hosts which aren't below domain,
+// including domain itself, and hosts with characters other than letters, digits, hyphens
+// and periods have no tenant.
+func tenantFromHost(host string, domain string) string {
+	domain = strings.Trim(strings.ToLower(domain), ".")
+	if domain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	tenant, ok := strings.CutSuffix(host, "."+domain)
+	if !ok || tenant == "" {
+		return ""
+	}
+	for _, c := range tenant {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '.' {
+			return ""
+		}
+	}
+	return tenant
+}
+
+// tenantIDPrefix returns the prefix of the IDs of all sessions of tenant
+func tenantIDPrefix(tenant string) string {
+	return tenant + sessionTenantSeparator
+}
+
+// inTenant returns whether the session with the given key, as passed to walkSessions,
+// belongs to tenant. Sessions of tenants below tenant don't belong to it.
+func (a *Application) inTenant(key string, tenant string) bool {
+	rest, ok := strings.CutPrefix(key, a.storageKey(tenantIDPrefix(tenant)))
+	return ok && !strings.Contains(rest, sessionTenantSeparator)
+}
+
+// sessionIDTenantPrefix returns the tenant prefix of the session ID id, which is empty
+// when the session doesn't belong to a tenant
+func sessionIDTenantPrefix(id string) string {
+	if i := strings.LastIndex(id, sessionTenantSeparator); i >= 0 {
+		return id[:i+len(sessionTenantSeparator)]
+	}
+	return ""
+}
+
+// renewedSessionID returns a new session ID in the tenant of the session ID id
+func renewedSessionID(id string) string {
+	return sessionIDTenantPrefix(id) + base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+// newSessionID returns a new session ID, prefixed with the tenant of host when the
+// application is configured with a tenant domain, so that the sessions of a tenant are
+// stored under their own prefix
+func (a *Application) newSessionID(host string) string {
+	id := base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	domain := config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionTenantDomain
+	if tenant := tenantFromHost(host, domain); tenant != "" {
+		return tenantIDPrefix(tenant) + id
+	}
+	return id
+}
@@ -0,0 +1,90 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+)
+
+func TestTenantFromHost(t *testing.T) {
+	for _, tc := range []struct {
+		host   string
+		domain string
+		tenant string
+	}{
+		{"foo.customer.example.com", "customer.example.com", "foo"},
+		{"foo.customer.example.com:8443", "customer.example.com", "foo"},
+		{"Foo.Customer.Example.com.", ".customer.example.com", "foo"},
+		{"a.b.customer.example.com", "customer.example.com", "a.b"},
+		{"customer.example.com", "customer.example.com", ""},
+		{"foocustomer.example.com", "customer.example.com", ""},
+		{"foo.other.example.com", "customer.example.com", ""},
+		{"foo_bar.customer.example.com", "customer.example.com", ""},
+		{"foo.customer.example.com", "", ""},
+	} {
+		assert.Equal(t, tc.tenant, tenantFromHost(tc.host, tc.domain), tc.host)
+	}
+}
+
+func TestNewSessionID(t *testing.T) {
+	a := newTestApplication(t)
+	assert.NotContains(t, a.newSessionID("foo.customer.example.com"), sessionTenantSeparator)
+
+	config.Get().Proxy.SessionTenantDomain = "customer.example.com"
+	defer func() {
+		config.Get().Proxy.SessionTenantDomain = ""
+	}()
+	assert.True(t, strings.HasPrefix(a.newSessionID("foo.customer.example.com"), "foo."))
+	assert.NotContains(t, a.newSessionID("customer.example.com"), sessionTenantSeparator)
+}
+
+func TestInTenant(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+	}()
+	a := newTestApplication(t)
+	dir := config.Get().Proxy.SessionDir
+	assert.True(t, a.inTenant(filepath.Join(dir, "session_foo.ABC"), "foo"))
+	assert.False(t, a.inTenant(filepath.Join(dir, "session_foo.bar.ABC"), "foo"))
+	assert.True(t, a.inTenant(filepath.Join(dir, "session_foo.bar.ABC"), "foo.bar"))
+	assert.False(t, a.inTenant(filepath.Join(dir, "session_foobar.ABC"), "foo"))
+	assert.False(t, a.inTenant(filepath.Join(dir, "session_ABC"), "foo"))
+}
+
+func TestLogoutTenant(t *testing.T) {
+	config.Get().Proxy.SessionBackend = SessionBackendMemory
+	defer func() {
+		config.Get().Proxy.SessionBackend = ""
+	}()
+	a := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "https://foo.customer.example.com/", nil)
+	for _, id := range []string{"foo.A", "foo.bar.B", "baz.C", "D"} {
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.ID = id
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = Claims{Sub: id}
+		assert.NoError(t, a.sessions.Save(req, httptest.NewRecorder(), s))
+	}
+
+	_, err := a.LogoutTenant(context.Background(), "", func(c Claims) bool { return true })
+	assert.Error(t, err)
+
+	deleted, err := a.LogoutTenant(context.Background(), "foo", func(c Claims) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	remaining := []string{}
+	a.sessions.(*memorystore.MemoryStore).Range(func(id string, values map[interface{}]interface{}) bool {
+		remaining = append(remaining, id)
+		return true
+	})
+	assert.ElementsMatch(t, []string{"foo.bar.B", "baz.C", "D"}, remaining)
+}
@@ -0,0 +1,175 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+	"goauthentik.io/internal/outpost/proxyv2/memorystore"
+	"goauthentik.io/internal/outpost/proxyv2/postgresstore"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// sessionTombstone is the session value holding the Unix time until which a session
+// which was logged out is kept for inspection, after which it is purged
+const sessionTombstone = "ak_tombstone_until"
+
+func (a *Application) logoutGracePeriod() time.Duration {
+	return config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionLogoutGracePeriod
+}
+
+// tombstoned returns whether the session with the given values was logged out and is
+// only kept until its grace period ends
+func tombstoned(values map[interface{}]interface{}) bool {
+	_, ok := values[sessionTombstone]
+	return ok
+}
+
+// liveSessionClaims returns the claims of the session with the given values like
+// sessionClaims, and false for tombstoned sessions
+func liveSessionClaims(values map[interface{}]interface{}) (Claims, bool) {
+	if tombstoned(values) {
+		return Claims{}, false
+	}
+	return sessionClaims(values)
+}
+
+// rejectTombstonedSession returns whether the session s was logged out. The tombstoned
+// session is kept in the store, and s is saved as a new session if it is used later in
+// the request.
+func (a *Application) rejectTombstonedSession(s *sessions.Session) bool {
+	if !tombstoned(s.Values) {
+		return false
+	}
+	a.log.Debug("session was logged out")
+	s.ID = ""
+	s.IsNew = true
+	clear(s.Values)
+	return true
+}
+
+// tombstoneMatchedSession marks the session with the given ID, as passed by walkSessions,
+// as logged out for the grace period instead of deleting it, and records the outcome in
+// result
+func (a *Application) tombstoneMatchedSession(ctx context.Context, id string, grace time.Duration, result *LogoutResult) {
+	a.log.WithField("id", id).Trace("tombstoning session")
+	ok, err := a.tombstoneSession(ctx, id, grace)
+	if err != nil {
+		a.log.WithError(err).WithField("id", id).Warning("failed to tombstone session")
+		result.Failed++
+		return
+	}
+	if ok {
+		result.Deleted++
+	}
+}
+
+// tombstoneSession marks the session with the given ID, as passed by walkSessions, as
+// logged out and saves it to expire once the grace period ends. It returns whether a
+// session was tombstoned. Sessions are rewritten under their storage key, as their session
+// ID can't be recovered from it when session ID hashing is enabled. Sessions of custom
+// stores are deleted instead.
+func (a *Application) tombstoneSession(ctx context.Context, id string, grace time.Duration) (bool, error) {
+	switch store := a.sessionStore().(type) {
+	case *filesystemstore.FilesystemStore:
+		return a.tombstoneSessionFile(store, id, grace)
+	case *redisstore.RedisStore:
+		return a.tombstoneRedisSession(ctx, store, id, grace)
+	case *memorystore.MemoryStore, *postgresstore.PostgresStore:
+		rec := &cookieRecorder{header: http.Header{}}
+		r, err := rec.request(ctx)
+		if err != nil {
+			return false, err
+		}
+		r.AddCookie(&http.Cookie{Name: a.SessionName(), Value: id})
+		s, err := store.New(r, a.SessionName())
+		if err != nil || s.IsNew {
+			// The session expired or was deleted since it was read
+			return false, err
+		}
+		s.Values[sessionTombstone] = time.Now().Add(grace).Unix()
+		s.Options.MaxAge = tombstoneMaxAge(grace)
+		return true, s.Save(r, rec)
+	}
+	// Custom stores can't be rewritten by their session ID
+	return a.deleteSession(id)
+}
+
+// tombstoneMaxAge returns the max age in seconds of sessions tombstoned for grace
+func tombstoneMaxAge(grace time.Duration) int {
+	return max(int(grace.Seconds()), 1)
+}
+
+// tombstoneSessionFile tombstones the session stored in the file at the given path. Files
+// which this application can't decode, such as those of other applications sharing the
+// session directory, are deleted instead.
+func (a *Application) tombstoneSessionFile(store *filesystemstore.FilesystemStore, filename string, grace time.Duration) (bool, error) {
+	data, err := store.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	values := map[interface{}]interface{}{}
+	// Decode without checking the timestamp, like the cleanup. Sessions are encoded again
+	// with their name, so that they are still loaded under their legacy cookie names.
+	name, err := a.decodeSessionFileName(data, &values, cookieSecretCodecs(0, a.proxyConfig))
+	if err != nil {
+		return a.deleteSession(filename)
+	}
+	values[sessionTombstone] = time.Now().Add(grace).Unix()
+	encoded, err := securecookie.EncodeMulti(name, values, store.Codecs...)
+	if err != nil {
+		return false, err
+	}
+	return true, store.WriteFile(filename, encoded)
+}
+
+// tombstoneRedisSession tombstones the session stored under the given key, and lets it
+// and its linked keys expire with the grace period
+func (a *Application) tombstoneRedisSession(ctx context.Context, store *redisstore.RedisStore, key string, grace time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout())
+	defer cancel()
+	client := store.Client()
+	b, err := client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	serializer := a.redisSerializer(client)
+	// Serializers are passed sessions with their storage ID, see RedisStore.StorageID
+	s := &sessions.Session{
+		ID:      strings.TrimPrefix(key, a.redisKeyPrefix()),
+		Values:  map[interface{}]interface{}{},
+		Options: &sessions.Options{MaxAge: tombstoneMaxAge(grace)},
+	}
+	if err := serializer.Deserialize(b, s); err != nil {
+		return false, err
+	}
+	s.Values[sessionTombstone] = time.Now().Add(grace).Unix()
+	b, err = serializer.Serialize(s)
+	if err != nil {
+		return false, err
+	}
+	// Sessions which were deleted meanwhile aren't recreated
+	return client.SetXX(ctx, key, b, time.Duration(s.Options.MaxAge)*time.Second).Result()
+}
+
+// tombstoneExpiry returns when the tombstoned session with the given values is purged,
+// which is zero for sessions which weren't logged out
+func tombstoneExpiry(values map[interface{}]interface{}) time.Time {
+	until, ok := values[sessionTombstone].(int64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(until, 0)
+}
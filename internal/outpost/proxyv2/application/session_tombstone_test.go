@@ -0,0 +1,121 @@
+package application
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+	"goauthentik.io/internal/outpost/proxyv2/filesystemstore"
+)
+
+func TestLogout_GracePeriod(t *testing.T) {
+	for _, backend := range []string{SessionBackendFilesystem, SessionBackendMemory} {
+		t.Run(backend, func(t *testing.T) {
+			config.Get().Proxy.SessionBackend = backend
+			config.Get().Proxy.SessionDir = t.TempDir()
+			config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+				"foo": {SessionLogoutGracePeriod: time.Hour},
+			}
+			defer func() {
+				config.Get().Proxy.SessionBackend = ""
+				config.Get().Proxy.SessionDir = ""
+				config.Get().Proxy.Applications = nil
+			}()
+			a := newTestApplication(t, withSlug("foo"))
+			defer a.Stop()
+
+			req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+			cookies := map[string]*http.Cookie{}
+			for _, sub := range []string{"foo", "bar"} {
+				s, _ := a.sessions.New(req, a.SessionName())
+				s.Options.MaxAge = 86400
+				s.Values[constants.SessionClaims] = Claims{Sub: sub}
+				rr := httptest.NewRecorder()
+				assert.NoError(t, a.sessions.Save(req, rr, s))
+				cookies[sub] = rr.Result().Cookies()[0]
+			}
+			request := func(sub string) (*sessions.Session, bool) {
+				r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+				r.AddCookie(cookies[sub])
+				_, ok := a.ClaimsFromRequest(r)
+				s, _ := a.sessions.New(r, a.SessionName())
+				return s, ok
+			}
+
+			deleted, err := a.LogoutUser(context.Background(), "foo")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, deleted)
+
+			// The session is kept, but rejected and no longer listed
+			s, ok := request("foo")
+			assert.False(t, ok)
+			assert.False(t, s.IsNew)
+			assert.True(t, tombstoned(s.Values))
+			_, ok = request("bar")
+			assert.True(t, ok)
+			all, err := a.Sessions(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, all, 1)
+
+			// Logging out again doesn't extend the grace period
+			deleted, err = a.LogoutUser(context.Background(), "foo")
+			assert.NoError(t, err)
+			assert.Equal(t, 0, deleted)
+
+			// Tombstoned session files are removed by the cleanup once the grace period ends
+			if store, ok := a.sessions.(*filesystemstore.FilesystemStore); ok {
+				assert.Equal(t, 0, a.cleanupSessions())
+				assert.WithinDuration(t, time.Now().Add(time.Hour), sessionFileExpiry(time.Now(), 24*time.Hour, s.Values), time.Minute)
+				s.Values[sessionTombstone] = time.Now().Add(-time.Hour).Unix()
+				s.Options.MaxAge = 86400
+				assert.NoError(t, store.Save(req, httptest.NewRecorder(), s))
+				assert.Equal(t, 1, a.cleanupSessions())
+				_, err := os.Stat(store.Filename(s.ID))
+				assert.True(t, os.IsNotExist(err))
+			}
+		})
+	}
+}
+
+func TestLogout_GracePeriodLegacySessionName(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+		"foo": {SessionLogoutGracePeriod: time.Hour},
+	}
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.Applications = nil
+	}()
+	a := newTestApplication(t, withSlug("foo"))
+	defer a.Stop()
+
+	// Sessions created before the session name included the slug are tombstoned as well
+	legacyName := a.legacySessionNames[0]
+	req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	s, _ := a.sessions.New(req, legacyName)
+	s.Options.MaxAge = 86400
+	s.Values[constants.SessionClaims] = Claims{Sub: "foo"}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, a.sessions.Save(req, rr, s))
+	cookie := rr.Result().Cookies()[0]
+
+	deleted, err := a.LogoutUser(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	r := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+	r.AddCookie(cookie)
+	_, ok := a.ClaimsFromRequest(r)
+	assert.False(t, ok)
+	s, err = a.sessions.New(r, legacyName)
+	assert.NoError(t, err)
+	assert.False(t, s.IsNew)
+	assert.True(t, tombstoned(s.Values))
+}
@@ -0,0 +1,50 @@
+package application
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/config"
+)
+
+// sessionUserAgent is the session value holding the fingerprint of the user agent of the
+// client which created the session
+const sessionUserAgent = "ak_user_agent"
+
+// userAgentVersion matches version numbers in user agents, such as 120.0.6099.71 or 10_15_7
+var userAgentVersion = regexp.MustCompile(`[0-9][0-9._]*`)
+
+// userAgentFingerprint returns a hash of the user agent of r with all version numbers
+// removed, so that the fingerprint doesn't change with browser or OS updates but does
+// when the browser, OS or device type is another
+func userAgentFingerprint(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	ua = strings.Join(strings.Fields(userAgentVersion.ReplaceAllString(ua, "")), " ")
+	h := sha256.Sum256([]byte(ua))
+	return hex.EncodeToString(h[:16])
+}
+
+// bindUserAgent stores the user agent fingerprint of r in s, when the user logs in
+func bindUserAgent(r *http.Request, s *sessions.Session) {
+	s.Values[sessionUserAgent] = userAgentFingerprint(r)
+}
+
+// deleteUserAgentMismatchSession deletes the session s of r when user agent binding is
+// enabled and the user agent of r doesn't match the one the session was created with, and
+// returns whether it was deleted. Sessions without a fingerprint aren't rejected.
+func (a *Application) deleteUserAgentMismatchSession(r *http.Request, s *sessions.Session, c Claims) bool {
+	if !config.Get().Proxy.ForApplication(a.proxyConfig.AssignedApplicationSlug).SessionUserAgentBinding {
+		return false
+	}
+	bound, ok := s.Values[sessionUserAgent].(string)
+	if !ok || s.ID == "" || bound == userAgentFingerprint(r) {
+		return false
+	}
+	a.log.WithField("sub", c.Sub).WithField("user_agent", r.UserAgent()).Warning("session presented from another user agent, deleting session")
+	a.discardSession(r, s)
+	return true
+}
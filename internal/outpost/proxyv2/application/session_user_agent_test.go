@@ -0,0 +1,64 @@
+package application
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/config"
+	"goauthentik.io/internal/outpost/proxyv2/constants"
+)
+
+const (
+	testChromeMac     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	testChromeMacNext = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.6167.85 Safari/537.36"
+	testFirefoxLinux  = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+)
+
+func TestUserAgentFingerprint(t *testing.T) {
+	fingerprint := func(ua string) string {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		req.Header.Set("User-Agent", ua)
+		return userAgentFingerprint(req)
+	}
+	// Version updates keep the fingerprint, other browsers change it
+	assert.Equal(t, fingerprint(testChromeMac), fingerprint(testChromeMacNext))
+	assert.NotEqual(t, fingerprint(testChromeMac), fingerprint(testFirefoxLinux))
+	assert.NotEqual(t, fingerprint(testChromeMac), fingerprint(""))
+}
+
+func TestDeleteUserAgentMismatchSession(t *testing.T) {
+	config.Get().Proxy.SessionDir = t.TempDir()
+	config.Get().Proxy.Applications = map[string]config.ProxyApplicationConfig{
+		"foo": {SessionUserAgentBinding: true},
+	}
+	defer func() {
+		config.Get().Proxy.SessionDir = ""
+		config.Get().Proxy.Applications = nil
+	}()
+	login := func(a *Application) func(ua string) bool {
+		req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+		req.Header.Set("User-Agent", testChromeMac)
+		s, _ := a.sessions.New(req, a.SessionName())
+		s.Options.MaxAge = 86400
+		s.Values[constants.SessionClaims] = Claims{Sub: "foo"}
+		bindUserAgent(req, s)
+		rr := httptest.NewRecorder()
+		assert.NoError(t, a.sessions.Save(req, rr, s))
+		cookie := rr.Result().Cookies()[0]
+		return func(ua string) bool {
+			req := httptest.NewRequest("GET", "https://ext.t.goauthentik.io/foo", nil)
+			req.Header.Set("User-Agent", ua)
+			req.AddCookie(cookie)
+			_, ok := a.ClaimsFromRequest(req)
+			return ok
+		}
+	}
+	// The binding is only enforced for applications which enable it
+	assert.True(t, login(newTestApplication(t))(testFirefoxLinux))
+	request := login(newTestApplication(t, withSlug("foo")))
+	assert.True(t, request(testChromeMacNext))
+	assert.False(t, request(testFirefoxLinux))
+	// The session was deleted
+	assert.False(t, request(testChromeMac))
+}
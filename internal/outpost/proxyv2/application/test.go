@@ -6,7 +6,9 @@ import (
 	"net/url"
 	"testing"
 
+	log "github.com/sirupsen/logrus"
 	"goauthentik.io/api/v3"
+	"goauthentik.io/internal/config"
 	"goauthentik.io/internal/outpost/ak"
 )
 
@@ -41,37 +43,115 @@ func (ts *testServer) Apps() []*Application {
 	return ts.apps
 }
 
-func newTestApplication() *Application {
+// testApplicationOptions are applied to a test application before it is created, and so
+// before its goroutines start
+type testApplicationOptions struct {
+	slug   string
+	logger *log.Logger
+}
+
+type testApplicationOption func(o *testApplicationOptions)
+
+// withSlug creates the test application as the application with the given slug
+func withSlug(slug string) testApplicationOption {
+	return func(o *testApplicationOptions) {
+		o.slug = slug
+	}
+}
+
+// withLogger creates the test application with a logger logging to logger
+func withLogger(logger *log.Logger) testApplicationOption {
+	return func(o *testApplicationOptions) {
+		o.logger = logger
+	}
+}
+
+// newTestApplication returns an application for tests, which is stopped when the test ends
+func newTestApplication(t *testing.T, opts ...testApplicationOption) *Application {
 	ts := newTestServer()
-	a, _ := NewApplication(
-		api.ProxyOutpostConfig{
-			Name:                       ak.TestSecret(),
-			ClientId:                   api.PtrString(ak.TestSecret()),
-			ClientSecret:               api.PtrString(ak.TestSecret()),
-			CookieDomain:               api.PtrString(""),
-			CookieSecret:               api.PtrString(ak.TestSecret()),
-			ExternalHost:               "https://ext.t.goauthentik.io",
-			InternalHost:               api.PtrString("http://backend"),
-			InternalHostSslValidation:  api.PtrBool(true),
-			Mode:                       api.PROXYMODE_FORWARD_SINGLE.Ptr(),
-			SkipPathRegex:              api.PtrString("/skip.*"),
-			BasicAuthEnabled:           api.PtrBool(true),
-			BasicAuthUserAttribute:     api.PtrString("username"),
-			BasicAuthPasswordAttribute: api.PtrString("password"),
-			OidcConfiguration: api.OpenIDConnectConfiguration{
-				AuthorizationEndpoint: "http://fake-auth.t.goauthentik.io/auth",
-				TokenEndpoint:         "http://fake-auth.t.goauthentik.io/token",
-				UserinfoEndpoint:      "http://fake-auth.t.goauthentik.io/userinfo",
-			},
+	p := api.ProxyOutpostConfig{
+		Name:                       ak.TestSecret(),
+		ClientId:                   api.PtrString(ak.TestSecret()),
+		ClientSecret:               api.PtrString(ak.TestSecret()),
+		CookieDomain:               api.PtrString(""),
+		CookieSecret:               api.PtrString(ak.TestSecret()),
+		ExternalHost:               "https://ext.t.goauthentik.io",
+		InternalHost:               api.PtrString("http://backend"),
+		InternalHostSslValidation:  api.PtrBool(true),
+		Mode:                       api.PROXYMODE_FORWARD_SINGLE.Ptr(),
+		SkipPathRegex:              api.PtrString("/skip.*"),
+		BasicAuthEnabled:           api.PtrBool(true),
+		BasicAuthUserAttribute:     api.PtrString("username"),
+		BasicAuthPasswordAttribute: api.PtrString("password"),
+		OidcConfiguration: api.OpenIDConnectConfiguration{
+			AuthorizationEndpoint: "http://fake-auth.t.goauthentik.io/auth",
+			TokenEndpoint:         "http://fake-auth.t.goauthentik.io/token",
+			UserinfoEndpoint:      "http://fake-auth.t.goauthentik.io/userinfo",
 		},
-		http.DefaultClient,
-		ts,
-		nil,
-	)
+	}
+	o := testApplicationOptions{logger: log.StandardLogger()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	p.AssignedApplicationSlug = o.slug
+	a, _ := newApplication(p, http.DefaultClient, ts, nil, o.logger)
+	t.Cleanup(a.Stop)
 	ts.apps = append(ts.apps, a)
 	return a
 }
 
+// useTestRedisStore replaces the session store of a with a Redis store on the Redis server
+// at localhost:6379, with the key prefix configured when it is called, and skips the test
+// when Redis isn't available
+func useTestRedisStore(t *testing.T, a *Application) {
+	rc := config.Get().Redis
+	config.Get().Proxy.SessionBackend = SessionBackendRedis
+	config.Get().Redis.Host = "localhost"
+	config.Get().Redis.Port = 6379
+	t.Cleanup(func() {
+		config.Get().Proxy.SessionBackend = ""
+		config.Get().Redis = rc
+	})
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	store, err := a.getStore(a.proxyConfig, u)
+	if err != nil {
+		t.Skip("redis is not available:", err)
+	}
+	a.sessionsMutex.Lock()
+	a.sessions = store
+	a.sessionsMutex.Unlock()
+}
+
+// useTestPostgresStore replaces the session store of a with a PostgreSQL store on the
+// database of the CI setup, with the key prefix configured when it is called, and skips
+// the test when PostgreSQL isn't available
+func useTestPostgresStore(t *testing.T, a *Application) {
+	pc := config.Get().PostgreSQL
+	config.Get().Proxy.SessionBackend = SessionBackendPostgres
+	config.Get().PostgreSQL = config.PostgreSQLConfig{
+		Host:     "localhost",
+		Port:     5432,
+		Name:     "authentik",
+		User:     "authentik",
+		Password: "EK-5jnKfjrGRm<77",
+	}
+	t.Cleanup(func() {
+		config.Get().Proxy.SessionBackend = ""
+		config.Get().PostgreSQL = pc
+	})
+	u, _ := url.Parse(a.proxyConfig.ExternalHost)
+	store, err := a.getStore(a.proxyConfig, u)
+	if err != nil {
+		t.Skip("postgresql is not available:", err)
+	}
+	a.sessionsMutex.Lock()
+	a.sessions = store
+	a.sessionsMutex.Unlock()
+	t.Cleanup(func() {
+		_ = a.Close()
+	})
+}
+
 func (a *Application) assertState(t *testing.T, req *http.Request, response *httptest.ResponseRecorder) (*url.URL, *OAuthState) {
 	loc, _ := response.Result().Location()
 	q := loc.Query()
@@ -14,13 +14,24 @@ func urlJoin(originalUrl string, newPath string) string {
 	return u
 }
 
-func (a *Application) redirect(rw http.ResponseWriter, r *http.Request) {
-	fallbackRedirect := a.proxyConfig.ExternalHost
+// redirect redirects to the URL from the state after login, falling back to the URL
+// originally requested as stored in the session, and then to the external host
+func (a *Application) redirect(rw http.ResponseWriter, r *http.Request, sessionRedirect string) {
 	state := a.stateFromRequest(r)
 	if state == nil {
 		rw.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	a.redirectWithState(rw, r, state, sessionRedirect)
+}
+
+// redirectWithState redirects like redirect with a state which was already verified, for
+// example before the session ID it is bound to was renewed
+func (a *Application) redirectWithState(rw http.ResponseWriter, r *http.Request, state *OAuthState, sessionRedirect string) {
+	fallbackRedirect := a.proxyConfig.ExternalHost
+	if state.Redirect == "" {
+		state.Redirect = sessionRedirect
+	}
 	if state.Redirect == "" {
 		state.Redirect = fallbackRedirect
 	}
@@ -11,7 +11,7 @@ import (
 )
 
 func TestRedirectToStart_Proxy(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_PROXY.Ptr()
 	a.proxyConfig.ExternalHost = "https://test.goauthentik.io"
 	req, _ := http.NewRequest("GET", "/foo/bar/baz", nil)
@@ -28,7 +28,7 @@ func TestRedirectToStart_Proxy(t *testing.T) {
 }
 
 func TestRedirectToStart_Forward(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_SINGLE.Ptr()
 	a.proxyConfig.ExternalHost = "https://test.goauthentik.io"
 	req, _ := http.NewRequest("GET", "/foo/bar/baz", nil)
@@ -45,7 +45,7 @@ func TestRedirectToStart_Forward(t *testing.T) {
 }
 
 func TestRedirectToStart_Forward_Domain_Invalid(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.CookieDomain = api.PtrString("foo")
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 	a.proxyConfig.ExternalHost = "https://test.goauthentik.io"
@@ -63,7 +63,7 @@ func TestRedirectToStart_Forward_Domain_Invalid(t *testing.T) {
 }
 
 func TestRedirectToStart_Forward_Domain(t *testing.T) {
-	a := newTestApplication()
+	a := newTestApplication(t)
 	a.proxyConfig.CookieDomain = api.PtrString("goauthentik.io")
 	a.proxyConfig.Mode = api.PROXYMODE_FORWARD_DOMAIN.Ptr()
 	a.proxyConfig.ExternalHost = "https://test.goauthentik.io"
@@ -0,0 +1,482 @@
+package filesystemstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// SessionFilePrefix is the default prefix of the name of every session file
+const SessionFilePrefix = "session_"
+
+// encryptedMagic marks session files which are encrypted at rest
+var encryptedMagic = []byte("akenc1:")
+
+var base32RawStdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var fileMutex sync.RWMutex
+
+// ErrSessionTooLong is returned when a session is saved whose encoded length exceeds the
+// maximum length of the store
+var ErrSessionTooLong = errors.New("filesystemstore: the session is too long")
+
+// Operations passed to the function set with ObserveLatency
+const (
+	OperationRead   = "read"
+	OperationWrite  = "write"
+	OperationDelete = "delete"
+)
+
+// FilesystemStore stores gorilla sessions in the filesystem. It is based on
+// sessions.FilesystemStore, with the option to encrypt session files at rest.
+type FilesystemStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	// directory in which session files are stored
+	path string
+	// prefix of the names of session files
+	prefix string
+	// optional cipher used to encrypt session files at rest
+	aead cipher.AEAD
+	// whether session files are compressed
+	compress bool
+	// size in bytes from which encoded sessions are compressed
+	compressThreshold int
+	// optional function called with the latency of every file operation
+	observe func(op string, d time.Duration)
+	// maximum length of encoded sessions, zero disables the limit
+	maxLength int
+	// optional function returning the ID under which a session is stored
+	storageID func(id string) string
+	// optional function returning the IDs under which a session was stored before
+	previousStorageIDs func(id string) []string
+	// prefixes session files were named with before
+	legacyPrefixes []string
+}
+
+// NewFilesystemStore returns a new FilesystemStore.
+//
+// The path argument is the directory where sessions will be saved. If empty
+// it will use os.TempDir().
+func NewFilesystemStore(path string, keyPairs ...[]byte) *FilesystemStore {
+	if path == "" {
+		path = os.TempDir()
+	}
+	fs := &FilesystemStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		path:   path,
+		prefix: SessionFilePrefix,
+	}
+
+	fs.MaxAge(fs.Options.MaxAge)
+	fs.MaxLength(4096)
+	return fs
+}
+
+// Path returns the directory in which session files are stored
+func (s *FilesystemStore) Path() string {
+	return s.path
+}
+
+// Prefix returns the prefix of the names of session files
+func (s *FilesystemStore) Prefix() string {
+	return s.prefix
+}
+
+// FilePrefix sets the prefix of the names of session files, so that stores sharing
+// a directory only consider their own files. Defaults to SessionFilePrefix.
+func (s *FilesystemStore) FilePrefix(prefix string) {
+	s.prefix = prefix
+}
+
+// IsSessionFile returns whether the file with the given name in the store's directory
+// is a session file of this store
+func (s *FilesystemStore) IsSessionFile(name string) bool {
+	return strings.HasPrefix(name, s.prefix)
+}
+
+// LegacyFilePrefixes sets the prefixes session files were named with before the current
+// prefix was set, such as SessionFilePrefix. Sessions which aren't found under their file
+// name are loaded from the file with any of these prefixes, which is renamed.
+func (s *FilesystemStore) LegacyFilePrefixes(prefixes ...string) {
+	s.legacyPrefixes = prefixes
+}
+
+// IsLegacySessionFile returns whether the file with the given name in the store's
+// directory may be a session file of this store named with a legacy prefix. Legacy
+// prefixes may be shared with other stores, so the file may as well belong to them.
+func (s *FilesystemStore) IsLegacySessionFile(name string) bool {
+	if s.IsSessionFile(name) {
+		return false
+	}
+	for _, prefix := range s.legacyPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageID sets a function returning the ID under which the session with the given ID is
+// stored, for example a hash of it so that file names don't reveal usable session IDs.
+// The session ID is still sent in the cookie.
+func (s *FilesystemStore) StorageID(fn func(id string) string) {
+	s.storageID = fn
+}
+
+// PreviousStorageIDs sets a function returning the IDs under which the session with the
+// given ID may have been stored before, for example hashes of it with keys which were
+// rotated since. Sessions which aren't found under their storage ID are loaded from the
+// first previous ID they are found under, and their file is renamed to their storage ID.
+func (s *FilesystemStore) PreviousStorageIDs(fn func(id string) []string) {
+	s.previousStorageIDs = fn
+}
+
+// previousFilenames returns the paths of the session files the session with the given ID
+// may have been stored in before, under a previous storage ID or a legacy prefix, see
+// PreviousStorageIDs and LegacyFilePrefixes
+func (s *FilesystemStore) previousFilenames(id string) []string {
+	ids := []string{id}
+	if s.storageID != nil {
+		ids[0] = s.storageID(id)
+	}
+	if s.previousStorageIDs != nil {
+		ids = append(ids, s.previousStorageIDs(id)...)
+	}
+	filenames := []string{}
+	for _, prefix := range append([]string{s.prefix}, s.legacyPrefixes...) {
+		for i, storageID := range ids {
+			// The first is the current file name of the session
+			if prefix == s.prefix && i == 0 {
+				continue
+			}
+			filenames = append(filenames, filepath.Join(s.path, prefix+filepath.Base(storageID)))
+		}
+	}
+	return filenames
+}
+
+// sessionFilename returns the path of the session file of the session with the given ID
+func (s *FilesystemStore) sessionFilename(id string) string {
+	if s.storageID != nil {
+		id = s.storageID(id)
+	}
+	return s.Filename(id)
+}
+
+// Filename returns the path of the session file of the session stored under the given
+// storage ID, see StorageID
+func (s *FilesystemStore) Filename(id string) string {
+	return filepath.Join(s.path, s.prefix+filepath.Base(id))
+}
+
+// SessionID returns the storage ID of the session stored in the session file at the given
+// path, and whether the file is a session file of this store
+func (s *FilesystemStore) SessionID(filename string) (string, bool) {
+	return strings.CutPrefix(filepath.Base(filename), s.prefix)
+}
+
+// EncryptionKey enables encryption of session files at rest with AES-GCM, using
+// a key derived from the given secret. Session files written before encryption
+// was enabled can still be read.
+func (s *FilesystemStore) EncryptionKey(secret []byte) error {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	s.aead = aead
+	return nil
+}
+
+// Compression enables gzip compression of session files. Session files written
+// before compression was enabled can still be read.
+func (s *FilesystemStore) Compression(enabled bool) {
+	s.compress = enabled
+}
+
+// CompressionThreshold sets the size in bytes from which encoded sessions are compressed
+// when compression is enabled, smaller sessions are stored uncompressed. Defaults to 0,
+// which compresses all sessions.
+func (s *FilesystemStore) CompressionThreshold(threshold int) {
+	s.compressThreshold = threshold
+}
+
+// ObserveLatency sets a function which is called with the operation and the latency of
+// every read, write and removal of a session file
+func (s *FilesystemStore) ObserveLatency(fn func(op string, d time.Duration)) {
+	s.observe = fn
+}
+
+// observeSince reports the latency of an operation started at start
+func (s *FilesystemStore) observeSince(op string, start time.Time) {
+	if s.observe != nil {
+		s.observe(op, time.Since(start))
+	}
+}
+
+// MaxLength restricts the maximum length of new sessions to l, saving a longer session
+// returns ErrSessionTooLong. Stored sessions are read regardless of their length.
+// If l is 0 there is no limit to the size of a session, use with caution.
+// The default for a new FilesystemStore is 4096.
+func (s *FilesystemStore) MaxLength(l int) {
+	s.maxLength = l
+	for _, c := range s.Codecs {
+		// Also matches codecs which embed a *securecookie.SecureCookie. The length is
+		// checked by the store, so that the error can be told apart
+		if codec, ok := c.(interface {
+			MaxLength(int) *securecookie.SecureCookie
+		}); ok {
+			codec.MaxLength(0)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *FilesystemStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (s *FilesystemStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	var err error
+	if c, errCookie := r.Cookie(name); errCookie == nil {
+		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
+		if err == nil {
+			err = s.load(session)
+			if err == nil {
+				session.IsNew = false
+			}
+		}
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+//
+// If the Options.MaxAge of the session is <= 0 then the session file will be
+// deleted from the store path. With this process it enforces the properly
+// session cookie handling so no need to trust in the cookie management in the
+// web browser.
+func (s *FilesystemStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	// Delete if max-age is <= 0
+	if session.Options.MaxAge <= 0 {
+		if err := s.erase(session); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		// Because the ID is used in the filename, encode it to
+		// use alphanumeric characters only.
+		session.ID = base32RawStdEncoding.EncodeToString(
+			securecookie.GenerateRandomKey(32))
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
+		s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// MaxAge sets the maximum age for the store and the underlying cookie
+// implementation. Individual sessions can be deleted by setting Options.MaxAge
+// = -1 for that session.
+func (s *FilesystemStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+
+	// Set the maxAge for each securecookie instance.
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Delete deletes the session file of the session with the given ID. Deleting a
+// session which doesn't exist is not an error.
+func (s *FilesystemStore) Delete(id string) error {
+	err := s.erase(&sessions.Session{ID: id})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ReadFile reads the session file at the given path, decrypting it if required,
+// and returns the encoded session values
+func (s *FilesystemStore) ReadFile(filename string) (string, error) {
+	fileMutex.RLock()
+	defer fileMutex.RUnlock()
+	start := time.Now()
+	fdata, err := os.ReadFile(filepath.Clean(filename))
+	s.observeSince(OperationRead, start)
+	if err != nil {
+		return "", err
+	}
+	data, err := s.decrypt(fdata)
+	if err != nil {
+		return "", err
+	}
+	data, err = redisstore.Decompress(data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// save writes encoded session.Values to a file.
+func (s *FilesystemStore) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
+		s.Codecs...)
+	if err != nil {
+		return err
+	}
+	if s.maxLength > 0 && len(encoded) > s.maxLength {
+		return fmt.Errorf("%w: %d bytes exceed the maximum of %d bytes", ErrSessionTooLong, len(encoded), s.maxLength)
+	}
+	return s.WriteFile(s.sessionFilename(session.ID), encoded)
+}
+
+// WriteFile writes the encoded session values to the session file at the given path,
+// compressing and encrypting them if enabled
+func (s *FilesystemStore) WriteFile(filename string, encoded string) error {
+	var err error
+	data := []byte(encoded)
+	if s.compress && len(data) >= s.compressThreshold {
+		data, err = redisstore.Compress(data)
+		if err != nil {
+			return err
+		}
+	}
+	data, err = s.encrypt(data)
+	if err != nil {
+		return err
+	}
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	defer s.observeSince(OperationWrite, time.Now())
+	return os.WriteFile(filename, data, 0600)
+}
+
+// load reads a file and decodes its content into session.Values.
+func (s *FilesystemStore) load(session *sessions.Session) error {
+	filename := s.sessionFilename(session.ID)
+	encoded, err := s.ReadFile(filename)
+	if os.IsNotExist(err) {
+		encoded, err = s.loadPrevious(session.ID, filename, err)
+	}
+	if err != nil {
+		return err
+	}
+	return securecookie.DecodeMulti(session.Name(), encoded,
+		&session.Values, s.Codecs...)
+}
+
+// loadPrevious reads the first previous session file of the session with the given ID
+// which exists, and renames it to filename, which keeps its modification time and so its
+// expiry. Returns notExist when none of the previous files exist.
+func (s *FilesystemStore) loadPrevious(id string, filename string, notExist error) (string, error) {
+	for _, previous := range s.previousFilenames(id) {
+		encoded, err := s.ReadFile(previous)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		// The session was read, failing to rename the file leaves it under its previous
+		// name, from which it is read again
+		fileMutex.Lock()
+		_ = os.Rename(previous, filename)
+		fileMutex.Unlock()
+		return encoded, nil
+	}
+	return "", notExist
+}
+
+// RemoveFile removes the session file at the given path. Unlike Delete, removing a file
+// which doesn't exist returns an error satisfying os.IsNotExist.
+func (s *FilesystemStore) RemoveFile(filename string) error {
+	fileMutex.RLock()
+	defer fileMutex.RUnlock()
+	defer s.observeSince(OperationDelete, time.Now())
+	return os.Remove(filepath.Clean(filename))
+}
+
+// delete session file, including previous session files of the session
+func (s *FilesystemStore) erase(session *sessions.Session) error {
+	for _, previous := range s.previousFilenames(session.ID) {
+		if err := s.RemoveFile(previous); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.RemoveFile(s.sessionFilename(session.ID))
+}
+
+// encrypt encrypts the encoded session values if encryption is enabled
+func (s *FilesystemStore) encrypt(encoded []byte) ([]byte, error) {
+	if s.aead == nil {
+		return encoded, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	data := append([]byte{}, encryptedMagic...)
+	data = append(data, nonce...)
+	return s.aead.Seal(data, nonce, encoded, nil), nil
+}
+
+// decrypt decrypts the contents of a session file. Files without the encryption
+// marker are returned as-is, so that sessions written before encryption was enabled
+// can still be read.
+func (s *FilesystemStore) decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	if s.aead == nil {
+		return nil, errors.New("filesystemstore: session file is encrypted but no encryption key is set")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("filesystemstore: encrypted session file is too short")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, ciphertext, nil)
+}
@@ -0,0 +1,267 @@
+package filesystemstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/stretchr/testify/assert"
+	"goauthentik.io/internal/outpost/proxyv2/codecs"
+)
+
+func testStore(t *testing.T) *FilesystemStore {
+	return NewFilesystemStore(t.TempDir(), securecookie.GenerateRandomKey(32))
+}
+
+func saveSession(t *testing.T, store *FilesystemStore) (*http.Request, string) {
+	req := httptest.NewRequest("GET", "http://www.example.com", nil)
+	w := httptest.NewRecorder()
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	session.Values["key"] = "value"
+	assert.NoError(t, session.Save(req, w))
+
+	req = httptest.NewRequest("GET", "http://www.example.com", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	return req, filepath.Join(store.Path(), SessionFilePrefix+session.ID)
+}
+
+func TestSaveLoad(t *testing.T) {
+	store := testStore(t)
+	req, _ := saveSession(t, store)
+
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "value", session.Values["key"])
+}
+
+func TestEncryption(t *testing.T) {
+	store := testStore(t)
+	assert.NoError(t, store.EncryptionKey([]byte("foo")))
+	req, filename := saveSession(t, store)
+
+	raw, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), string(encryptedMagic)))
+
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "value", session.Values["key"])
+
+	// A different key can't decrypt the session
+	assert.NoError(t, store.EncryptionKey([]byte("bar")))
+	_, err = store.ReadFile(filename)
+	assert.Error(t, err)
+}
+
+func TestEncryption_Plaintext(t *testing.T) {
+	store := testStore(t)
+	req, filename := saveSession(t, store)
+
+	// Sessions written before encryption was enabled can still be read
+	assert.NoError(t, store.EncryptionKey([]byte("foo")))
+	data, err := store.ReadFile(filename)
+	assert.NoError(t, err)
+	raw, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, string(raw), data)
+
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", session.Values["key"])
+}
+
+func TestDelete(t *testing.T) {
+	store := testStore(t)
+	req, filename := saveSession(t, store)
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Delete(session.ID))
+	_, err = os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+	// Deleting again is a no-op
+	assert.NoError(t, store.Delete(session.ID))
+}
+
+func TestMaxLength(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir())
+	store.Codecs = codecs.CodecsFromPairs(0, securecookie.GenerateRandomKey(32))
+	store.MaxLength(10)
+
+	req := httptest.NewRequest("GET", "http://www.example.com", nil)
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	session.Values["key"] = "value"
+	assert.ErrorIs(t, session.Save(req, httptest.NewRecorder()), ErrSessionTooLong)
+	_, err = os.Stat(store.Filename(session.ID))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCompression(t *testing.T) {
+	store := testStore(t)
+	plainReq, _ := saveSession(t, store)
+	store.Compression(true)
+	assert.NoError(t, store.EncryptionKey([]byte("foo")))
+	req, filename := saveSession(t, store)
+
+	data, err := store.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.False(t, strings.HasPrefix(data, "akgz1:"))
+
+	// Both compressed and uncompressed sessions can be read
+	for _, r := range []*http.Request{req, plainReq} {
+		session, err := store.New(r, "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", session.Values["key"])
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	store := testStore(t)
+	store.Compression(true)
+	store.CompressionThreshold(4096)
+	smallReq, filename := saveSession(t, store)
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.False(t, strings.HasPrefix(string(data), "akgz1:"))
+
+	store.CompressionThreshold(0)
+	req, filename := saveSession(t, store)
+	data, err = os.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "akgz1:"))
+
+	for _, r := range []*http.Request{req, smallReq} {
+		session, err := store.New(r, "hello")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", session.Values["key"])
+	}
+}
+
+func TestFilePrefix(t *testing.T) {
+	store := testStore(t)
+	store.FilePrefix("session_foo.")
+	_, filename := saveSession(t, store)
+	assert.NoFileExists(t, filename)
+
+	files, err := os.ReadDir(store.Path())
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.True(t, strings.HasPrefix(files[0].Name(), "session_foo."))
+	assert.True(t, store.IsSessionFile(files[0].Name()))
+
+	// Stores with a different prefix in the same directory ignore the file
+	other := NewFilesystemStore(store.Path())
+	other.FilePrefix("session_foo_bar.")
+	assert.False(t, other.IsSessionFile(files[0].Name()))
+}
+
+func TestLegacyFilePrefixes(t *testing.T) {
+	store := testStore(t)
+	// Sessions were saved without a prefix of their own
+	req, legacy := saveSession(t, store)
+	assert.FileExists(t, legacy)
+
+	store.FilePrefix("session_foo.")
+	store.LegacyFilePrefixes(SessionFilePrefix)
+	assert.True(t, store.IsLegacySessionFile(filepath.Base(legacy)))
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "value", session.Values["key"])
+	// The file is renamed when it is loaded
+	assert.NoFileExists(t, legacy)
+	assert.FileExists(t, store.Filename(session.ID))
+	assert.False(t, store.IsLegacySessionFile(filepath.Base(store.Filename(session.ID))))
+	assert.True(t, store.IsSessionFile(filepath.Base(store.Filename(session.ID))))
+}
+
+func TestObserveLatency(t *testing.T) {
+	store := testStore(t)
+	ops := []string{}
+	store.ObserveLatency(func(op string, d time.Duration) {
+		ops = append(ops, op)
+	})
+	req, filename := saveSession(t, store)
+	_, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.NoError(t, store.RemoveFile(filename))
+	assert.True(t, os.IsNotExist(store.RemoveFile(filename)))
+	assert.Equal(t, []string{OperationWrite, OperationRead, OperationDelete, OperationDelete}, ops)
+}
+
+func TestSessionID(t *testing.T) {
+	store := testStore(t)
+	store.FilePrefix("session_foo.")
+	id, ok := store.SessionID(store.Filename("bar"))
+	assert.True(t, ok)
+	assert.Equal(t, "bar", id)
+	_, ok = store.SessionID(filepath.Join(store.Path(), "session_bar"))
+	assert.False(t, ok)
+}
+
+func TestStorageID(t *testing.T) {
+	store := testStore(t)
+	store.StorageID(func(id string) string {
+		return "hashed" + strings.ToLower(id)
+	})
+	req, filename := saveSession(t, store)
+	_, err := os.Stat(filename)
+	assert.True(t, os.IsNotExist(err))
+
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "value", session.Values["key"])
+	_, err = os.Stat(store.Filename("hashed" + strings.ToLower(session.ID)))
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Delete(session.ID))
+	session, err = store.New(req, "hello")
+	assert.Error(t, err)
+	assert.True(t, session.IsNew)
+}
+
+func TestPreviousStorageIDs(t *testing.T) {
+	store := testStore(t)
+	store.StorageID(func(id string) string {
+		return "old" + strings.ToLower(id)
+	})
+	req, _ := saveSession(t, store)
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	previous := store.Filename("old" + strings.ToLower(session.ID))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(previous, time.Time{}, mtime))
+
+	// Sessions stored under a previous ID are moved to their current ID when loaded
+	store.StorageID(func(id string) string {
+		return "new" + strings.ToLower(id)
+	})
+	store.PreviousStorageIDs(func(id string) []string {
+		return []string{"other" + strings.ToLower(id), "old" + strings.ToLower(id)}
+	})
+	session, err = store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "value", session.Values["key"])
+	_, err = os.Stat(previous)
+	assert.True(t, os.IsNotExist(err))
+	info, err := os.Stat(store.Filename("new" + strings.ToLower(session.ID)))
+	assert.NoError(t, err)
+	assert.Equal(t, mtime, info.ModTime())
+
+	// Deleting a session removes it under its previous IDs as well
+	assert.NoError(t, os.Rename(store.Filename("new"+strings.ToLower(session.ID)), previous))
+	assert.NoError(t, store.Delete(session.ID))
+	_, err = os.Stat(previous)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -28,6 +28,19 @@ func (ps *ProxyServer) HandlePing(rw http.ResponseWriter, r *http.Request) {
 	}).Observe(float64(elapsed) / float64(time.Second))
 }
 
+// HandleReady responds with 503 when the session backend of any application is unreachable,
+// to be used as readiness probe
+func (ps *ProxyServer) HandleReady(rw http.ResponseWriter, r *http.Request) {
+	for _, app := range ps.apps {
+		if err := app.Healthy(r.Context()); err != nil {
+			ps.log.WithError(err).WithField("app", app.ProxyConfig().Name).Warning("session backend is not reachable")
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
 func (ps *ProxyServer) HandleStatic(rw http.ResponseWriter, r *http.Request) {
 	before := time.Now()
 	web.DisableIndex(http.StripPrefix("/outpost.goauthentik.io/static/dist", staticWeb.StaticHandler)).ServeHTTP(rw, r)
@@ -60,7 +73,8 @@ func (ps *ProxyServer) lookupApp(r *http.Request) (*application.Application, str
 		// Check if the cookie domain has a leading period for a wildcard
 		// This will decrease the weight of a wildcard domain, but a request to example.com
 		// with the cookie domain set to example.com will still be routed correctly.
-		cd := strings.TrimPrefix(*app.ProxyConfig().CookieDomain, ".")
+		pc := app.ProxyConfig()
+		cd := strings.TrimPrefix(pc.GetCookieDomain(), ".")
 		if !strings.HasSuffix(host, cd) {
 			continue
 		}
@@ -93,6 +107,10 @@ func (ps *ProxyServer) Handle(rw http.ResponseWriter, r *http.Request) {
 		sentryutils.SentryNoSample(ps.HandlePing)(rw, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/outpost.goauthentik.io/ready") {
+		sentryutils.SentryNoSample(ps.HandleReady)(rw, r)
+		return
+	}
 	a, host := ps.lookupApp(r)
 	if a == nil {
 		// If we only have one handler, host name switching doesn't matter
@@ -0,0 +1,169 @@
+package memorystore
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// MemoryStore stores gorilla sessions in memory, which means sessions are
+// lost when the process exits and are not shared between replicas
+type MemoryStore struct {
+	// stored sessions, keyed by session ID
+	sessions sync.Map
+	// default options to use when a new session is created
+	options sessions.Options
+	// session serializer, sessions are serialized so that they behave the same
+	// as sessions in other stores
+	serializer redisstore.SessionSerializer
+}
+
+var errNotFound = errors.New("memorystore: session not found")
+
+type entry struct {
+	data    []byte
+	expires time.Time
+}
+
+func (e entry) expired() bool {
+	return !e.expires.IsZero() && time.Now().After(e.expires)
+}
+
+// NewMemoryStore returns a new MemoryStore with default configuration
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		options: sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		serializer: redisstore.GobSerializer{},
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *MemoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (s *MemoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	session.ID = c.Value
+
+	err = s.load(session)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return session, nil
+		}
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save adds a single session to the response.
+//
+// If the Options.MaxAge of the session is <= 0 then the session is deleted
+// from the store.
+func (s *MemoryStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	// Delete if max-age is <= 0
+	if session.Options.MaxAge <= 0 {
+		s.Delete(session.ID)
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		k := securecookie.GenerateRandomKey(64)
+		if k == nil {
+			return errors.New("memorystore: failed to generate session id")
+		}
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(k), "=")
+	}
+	b, err := s.serializer.Serialize(session)
+	if err != nil {
+		return err
+	}
+	s.sessions.Store(session.ID, entry{
+		data:    b,
+		expires: time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second),
+	})
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), session.ID, session.Options))
+	return nil
+}
+
+// Options set options to use when a new session is created
+func (s *MemoryStore) Options(opts sessions.Options) {
+	s.options = opts
+}
+
+// Serializer sets the session serializer to store session
+func (s *MemoryStore) Serializer(ss redisstore.SessionSerializer) {
+	s.serializer = ss
+}
+
+// Range calls fn for the values of every session that hasn't expired yet.
+// If fn returns false, the iteration is stopped.
+func (s *MemoryStore) Range(fn func(id string, values map[interface{}]interface{}) bool) {
+	s.sessions.Range(func(key, value any) bool {
+		e := value.(entry)
+		if e.expired() {
+			s.sessions.Delete(key)
+			return true
+		}
+		session := sessions.Session{}
+		if err := s.serializer.Deserialize(e.data, &session); err != nil {
+			return true
+		}
+		return fn(key.(string), session.Values)
+	})
+}
+
+// Expires returns when the session with the given ID expires, and false if
+// there is no such session or it has expired
+func (s *MemoryStore) Expires(id string) (time.Time, bool) {
+	v, ok := s.sessions.Load(id)
+	if !ok {
+		return time.Time{}, false
+	}
+	e := v.(entry)
+	if e.expired() {
+		return time.Time{}, false
+	}
+	return e.expires, true
+}
+
+// Delete removes the session with the given ID from the store
+func (s *MemoryStore) Delete(id string) {
+	s.sessions.Delete(id)
+}
+
+// load reads session from memory
+func (s *MemoryStore) load(session *sessions.Session) error {
+	v, ok := s.sessions.Load(session.ID)
+	if !ok {
+		return errNotFound
+	}
+	e := v.(entry)
+	if e.expired() {
+		s.sessions.Delete(session.ID)
+		return errNotFound
+	}
+	return s.serializer.Deserialize(e.data, session)
+}
@@ -0,0 +1,133 @@
+package memorystore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestNew(t *testing.T) {
+	store := NewMemoryStore()
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+	if session.IsNew == false {
+		t.Fatal("session is not new")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	store := NewMemoryStore()
+
+	opts := sessions.Options{
+		Path:   "/path",
+		MaxAge: 99999,
+	}
+	store.Options(opts)
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create store", err)
+	}
+	if session.Options.Path != opts.Path || session.Options.MaxAge != opts.MaxAge {
+		t.Fatal("failed to set options")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	store := NewMemoryStore()
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	w := httptest.NewRecorder()
+
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+
+	session.Values["key"] = "value"
+	err = session.Save(req, w)
+	if err != nil {
+		t.Fatal("failed to save: ", err)
+	}
+
+	req, err = http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	req.AddCookie(w.Result().Cookies()[0])
+	session, err = store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to load session", err)
+	}
+	if session.IsNew || session.Values["key"] != "value" {
+		t.Fatal("failed to load saved session")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	store := NewMemoryStore()
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	w := httptest.NewRecorder()
+
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+
+	session.Values["key"] = "value"
+	err = session.Save(req, w)
+	if err != nil {
+		t.Fatal("failed to save session: ", err)
+	}
+
+	session.Options.MaxAge = -1
+	err = session.Save(req, w)
+	if err != nil {
+		t.Fatal("failed to delete session: ", err)
+	}
+	if err := store.load(session); err == nil {
+		t.Fatal("session was not deleted")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	store.sessions.Store("expired", entry{
+		expires: time.Now().Add(-time.Second),
+	})
+	session := sessions.NewSession(store, "hello")
+	session.ID = "expired"
+	if err := store.load(session); err == nil {
+		t.Fatal("expired session was loaded")
+	}
+	store.Range(func(id string, values map[interface{}]interface{}) bool {
+		t.Fatal("expired session was returned by range")
+		return true
+	})
+	if _, ok := store.Expires("expired"); ok {
+		t.Fatal("expired session has an expiry")
+	}
+}
@@ -22,15 +22,38 @@ var (
 		Name: "authentik_outpost_proxy_upstream_response_duration_seconds",
 		Help: "Proxy upstream response latencies in seconds",
 	}, []string{"outpost_name", "method", "scheme", "host", "upstream_host"})
+	Sessions = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "authentik_outpost_proxy_sessions",
+		Help: "Number of sessions in the session store of an application",
+	}, []string{"outpost_name", "application"})
+	SessionDecodeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "authentik_outpost_proxy_session_decode_errors_total",
+		Help: "Number of sessions which failed to decode, for example after rotating the cookie secret",
+	}, []string{"outpost_name", "backend"})
+	SessionBackendFallback = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "authentik_outpost_proxy_session_backend_fallback",
+		Help: "Whether an application stores sessions in a different backend than the configured one",
+	}, []string{"outpost_name", "application"})
+	SessionStoreInitFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "authentik_outpost_proxy_store_init_failures_total",
+		Help: "Number of times the session store of an application failed to be created",
+	}, []string{"outpost_name", "application", "backend", "reason"})
+	SessionStoreTiming = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "authentik_outpost_proxy_session_store_duration_seconds",
+		Help:    "Session store operation latencies in seconds",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"outpost_name", "backend", "operation"})
 )
 
-func RunServer() {
+// RunServer starts the metrics server, which also serves the readiness probe ready
+func RunServer(ready http.HandlerFunc) {
 	m := mux.NewRouter()
 	l := log.WithField("logger", "authentik.outpost.metrics")
 	m.Use(sentry.SentryNoSampleMiddleware)
 	m.HandleFunc("/outpost.goauthentik.io/ping", func(rw http.ResponseWriter, r *http.Request) {
 		rw.WriteHeader(204)
 	})
+	m.HandleFunc("/outpost.goauthentik.io/ready", ready)
 	m.Path("/metrics").Handler(promhttp.Handler())
 	listen := config.Get().Listen.Metrics
 	l.WithField("listen", listen).Info("Starting Metrics server")
@@ -0,0 +1,278 @@
+package postgresstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"goauthentik.io/internal/outpost/proxyv2/redisstore"
+)
+
+// DefaultTable is the table sessions are stored in
+const DefaultTable = "authentik_outpost_proxy_session"
+
+// WalkBatchSize is the number of sessions read with each query while walking sessions
+const WalkBatchSize = 100
+
+var errNotFound = errors.New("postgresstore: session not found")
+
+// PostgresStore stores gorilla sessions in a PostgreSQL table. Sessions are stored with a
+// namespace, so that applications sharing the table don't see each other's sessions.
+type PostgresStore struct {
+	// pool of connections to the database
+	pool *pgxpool.Pool
+	// name of the table sessions are stored in
+	table string
+	// namespace of the sessions of this store
+	namespace string
+	// default options to use when a new session is created
+	options sessions.Options
+	// session serializer
+	serializer redisstore.SessionSerializer
+	// maximum length of serialized sessions, zero disables the limit
+	maxLength int
+}
+
+// NewPostgresStore returns a new PostgresStore with default configuration, which stores
+// sessions in DefaultTable
+func NewPostgresStore(ctx context.Context, pool *pgxpool.Pool) (*PostgresStore, error) {
+	ps := &PostgresStore{
+		pool:  pool,
+		table: DefaultTable,
+		options: sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		serializer: redisstore.GobSerializer{},
+	}
+	return ps, pool.Ping(ctx)
+}
+
+// Pool returns the connection pool of the store, which isn't closed by the store as it may
+// be shared with other stores
+func (s *PostgresStore) Pool() *pgxpool.Pool {
+	return s.pool
+}
+
+// Table sets the name of the table sessions are stored in, see CreateTable
+func (s *PostgresStore) Table(name string) {
+	s.table = name
+}
+
+// Namespace sets the namespace of the sessions of this store
+func (s *PostgresStore) Namespace(namespace string) {
+	s.namespace = namespace
+}
+
+// CreateTable creates the session table and the index on the expiry of sessions used by
+// DeleteExpired, unless they exist already
+func (s *PostgresStore) CreateTable(ctx context.Context) error {
+	index := pgx.Identifier{s.table + "_expires"}.Sanitize()
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		namespace text NOT NULL,
+		id text NOT NULL,
+		value bytea NOT NULL,
+		expires timestamptz NOT NULL,
+		PRIMARY KEY (namespace, id)
+	)`, s.quotedTable()))
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (expires)`, index, s.quotedTable()))
+	return err
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *PostgresStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (s *PostgresStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := s.options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	session.ID = c.Value
+
+	err = s.load(r.Context(), session)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return session, nil
+		}
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save adds a single session to the response.
+//
+// If the Options.MaxAge of the session is <= 0 then the session is deleted
+// from the store.
+func (s *PostgresStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	// Delete if max-age is <= 0
+	if session.Options.MaxAge <= 0 {
+		if err := s.Delete(r.Context(), session.ID); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := generateRandomKey()
+		if err != nil {
+			return errors.New("postgresstore: failed to generate session id")
+		}
+		session.ID = id
+	}
+	if err := s.save(r.Context(), session); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), session.ID, session.Options))
+	return nil
+}
+
+// Options set options to use when a new session is created
+func (s *PostgresStore) Options(opts sessions.Options) {
+	s.options = opts
+}
+
+// Serializer sets the session serializer to store session
+func (s *PostgresStore) Serializer(ss redisstore.SessionSerializer) {
+	s.serializer = ss
+}
+
+// MaxLength restricts the maximum length of serialized sessions, zero disables the limit
+func (s *PostgresStore) MaxLength(l int) {
+	s.maxLength = l
+}
+
+// Walk calls fn with the ID and values of every session of the namespace which hasn't
+// expired, until fn returns false. Sessions are read in batches ordered by their ID, and no
+// query is running while fn is called, so fn can delete sessions. Sessions which can't be
+// deserialized are skipped.
+func (s *PostgresStore) Walk(ctx context.Context, fn func(id string, values map[interface{}]interface{}) bool) error {
+	after := ""
+	for {
+		rows, err := s.pool.Query(ctx, fmt.Sprintf(
+			`SELECT id, value FROM %s WHERE namespace = $1 AND id > $2 AND expires > now() ORDER BY id LIMIT %d`,
+			s.quotedTable(), WalkBatchSize,
+		), s.namespace, after)
+		if err != nil {
+			return err
+		}
+		type row struct {
+			id    string
+			value []byte
+		}
+		batch, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (row, error) {
+			var res row
+			err := r.Scan(&res.id, &res.value)
+			return res, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, r := range batch {
+			session := sessions.Session{ID: r.id}
+			if err := s.serializer.Deserialize(r.value, &session); err != nil {
+				continue
+			}
+			if !fn(r.id, session.Values) {
+				return nil
+			}
+		}
+		if len(batch) < WalkBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].id
+	}
+}
+
+// Count returns the number of sessions of the namespace which haven't expired
+func (s *PostgresStore) Count(ctx context.Context) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT count(*) FROM %s WHERE namespace = $1 AND expires > now()`, s.quotedTable(),
+	), s.namespace).Scan(&count)
+	return count, err
+}
+
+// Delete deletes the session with the given ID, deleting a session which doesn't exist
+// is not an error
+func (s *PostgresStore) Delete(ctx context.Context, id string) error {
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE namespace = $1 AND id = $2`, s.quotedTable()), s.namespace, id)
+	return err
+}
+
+// DeleteExpired deletes the expired sessions of all namespaces, which uses the index on
+// the expiry of sessions, and returns the number of deleted sessions
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int, error) {
+	tag, err := s.pool.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires <= now()`, s.quotedTable()))
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// save writes session to the table, replacing its expiry with its current MaxAge
+func (s *PostgresStore) save(ctx context.Context, session *sessions.Session) error {
+	b, err := s.serializer.Serialize(session)
+	if err != nil {
+		return err
+	}
+	if s.maxLength > 0 && len(b) > s.maxLength {
+		return errors.New("postgresstore: the value is too long")
+	}
+	expires := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	_, err = s.pool.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (namespace, id, value, expires) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (namespace, id) DO UPDATE SET value = EXCLUDED.value, expires = EXCLUDED.expires`, s.quotedTable()),
+		s.namespace, session.ID, b, expires)
+	return err
+}
+
+// load reads session from the table, sessions which expired aren't found
+func (s *PostgresStore) load(ctx context.Context, session *sessions.Session) error {
+	var b []byte
+	err := s.pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT value FROM %s WHERE namespace = $1 AND id = $2 AND expires > now()`, s.quotedTable(),
+	), s.namespace, session.ID).Scan(&b)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.serializer.Deserialize(b, session)
+}
+
+// quotedTable returns the name of the session table quoted for use in queries
+func (s *PostgresStore) quotedTable() string {
+	return pgx.Identifier{s.table}.Sanitize()
+}
+
+// generateRandomKey returns a new random key
+func generateRandomKey() (string, error) {
+	k := make([]byte, 64)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(k), "="), nil
+}
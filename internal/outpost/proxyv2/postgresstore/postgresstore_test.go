@@ -0,0 +1,151 @@
+package postgresstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// postgresConnString connects to the database of the CI setup
+	postgresConnString = "host=localhost port=5432 user=authentik password='EK-5jnKfjrGRm<77' dbname=authentik"
+	testTable          = "authentik_outpost_proxy_session_test"
+)
+
+func testStore(t *testing.T) *PostgresStore {
+	pool, err := pgxpool.New(context.Background(), postgresConnString)
+	if err != nil {
+		t.Fatal("failed to create pool", err)
+	}
+	t.Cleanup(pool.Close)
+	store, err := NewPostgresStore(context.Background(), pool)
+	if err != nil {
+		t.Skip("postgresql is not available:", err)
+	}
+	store.Table(testTable)
+	store.Namespace(uuid.NewString())
+	if err := store.CreateTable(context.Background()); err != nil {
+		t.Fatal("failed to create table", err)
+	}
+	return store
+}
+
+func saveSession(t *testing.T, store *PostgresStore, maxAge int) (*http.Request, *sessions.Session) {
+	req := httptest.NewRequest("GET", "http://www.example.com", nil)
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	session.Options.MaxAge = maxAge
+	session.Values["key"] = "value"
+	w := httptest.NewRecorder()
+	assert.NoError(t, session.Save(req, w))
+
+	req = httptest.NewRequest("GET", "http://www.example.com", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	return req, session
+}
+
+func TestSaveLoad(t *testing.T) {
+	store := testStore(t)
+	req, _ := saveSession(t, store, 3600)
+
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+	assert.Equal(t, "value", session.Values["key"])
+
+	// Sessions of other namespaces aren't found
+	other := testStore(t)
+	session, err = other.New(req, "hello")
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+}
+
+func TestDelete(t *testing.T) {
+	store := testStore(t)
+	req, session := saveSession(t, store, 3600)
+
+	session.Options.MaxAge = -1
+	assert.NoError(t, session.Save(req, httptest.NewRecorder()))
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+
+	// Deleting again is a no-op
+	assert.NoError(t, store.Delete(context.Background(), session.ID))
+}
+
+func TestMaxLength(t *testing.T) {
+	store := testStore(t)
+	store.MaxLength(10)
+	req := httptest.NewRequest("GET", "http://www.example.com", nil)
+	session, err := store.New(req, "hello")
+	assert.NoError(t, err)
+	session.Values["key"] = "value"
+	assert.Error(t, session.Save(req, httptest.NewRecorder()))
+}
+
+func TestDeleteExpired(t *testing.T) {
+	store := testStore(t)
+	expiredReq, expired := saveSession(t, store, 3600)
+	req, _ := saveSession(t, store, 3600)
+	_, err := store.pool.Exec(context.Background(), fmt.Sprintf(
+		`UPDATE %s SET expires = now() - interval '1 minute' WHERE namespace = $1 AND id = $2`, store.quotedTable(),
+	), store.namespace, expired.ID)
+	assert.NoError(t, err)
+
+	// Expired sessions aren't loaded, listed or counted until they are deleted
+	session, err := store.New(expiredReq, "hello")
+	assert.NoError(t, err)
+	assert.True(t, session.IsNew)
+	count, err := store.Count(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	deleted, err := store.DeleteExpired(context.Background())
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, 1)
+	session, err = store.New(req, "hello")
+	assert.NoError(t, err)
+	assert.False(t, session.IsNew)
+}
+
+func TestWalk(t *testing.T) {
+	store := testStore(t)
+	ids := map[string]struct{}{}
+	for i := 0; i < WalkBatchSize+5; i++ {
+		_, session := saveSession(t, store, 3600)
+		ids[session.ID] = struct{}{}
+	}
+	_, _ = saveSession(t, testStore(t), 3600)
+
+	// Sessions can be deleted while they are walked
+	walked := map[string]struct{}{}
+	err := store.Walk(context.Background(), func(id string, values map[interface{}]interface{}) bool {
+		walked[id] = struct{}{}
+		assert.Equal(t, "value", values["key"])
+		assert.NoError(t, store.Delete(context.Background(), id))
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ids, walked)
+	count, err := store.Count(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Walking stops when fn returns false
+	saveSession(t, store, 3600)
+	saveSession(t, store, 3600)
+	calls := 0
+	assert.NoError(t, store.Walk(context.Background(), func(id string, values map[interface{}]interface{}) bool {
+		calls++
+		return false
+	}))
+	assert.Equal(t, 1, calls)
+}
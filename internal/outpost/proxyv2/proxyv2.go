@@ -65,6 +65,7 @@ func NewProxyServer(ac *ak.APIController) *ProxyServer {
 	}
 	globalMux.PathPrefix("/outpost.goauthentik.io/static").HandlerFunc(s.HandleStatic)
 	globalMux.Path("/outpost.goauthentik.io/ping").HandlerFunc(sentryutils.SentryNoSample(s.HandlePing))
+	globalMux.Path("/outpost.goauthentik.io/ready").HandlerFunc(sentryutils.SentryNoSample(s.HandleReady))
 	rootMux.PathPrefix("/").HandlerFunc(s.Handle)
 	ac.AddWSHandler(s.handleWSMessage)
 	return s
@@ -73,6 +74,7 @@ func NewProxyServer(ac *ak.APIController) *ProxyServer {
 func (ps *ProxyServer) HandleHost(rw http.ResponseWriter, r *http.Request) bool {
 	// Always handle requests for outpost paths that should answer regardless of hostname
 	if strings.HasPrefix(r.URL.Path, "/outpost.goauthentik.io/ping") ||
+		strings.HasPrefix(r.URL.Path, "/outpost.goauthentik.io/ready") ||
 		strings.HasPrefix(r.URL.Path, "/outpost.goauthentik.io/static") {
 		ps.mux.ServeHTTP(rw, r)
 		return true
@@ -180,12 +182,18 @@ func (ps *ProxyServer) Start() error {
 	}()
 	go func() {
 		defer wg.Done()
-		metrics.RunServer()
+		metrics.RunServer(ps.HandleReady)
 	}()
 	return nil
 }
 
 func (ps *ProxyServer) Stop() error {
+	for _, app := range ps.apps {
+		app.Stop()
+		if err := app.Close(); err != nil {
+			ps.log.WithError(err).Warning("failed to close session store")
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,68 @@
+package redisstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/gorilla/sessions"
+)
+
+// gzipMagic marks sessions which are compressed with gzip
+var gzipMagic = []byte("akgz1:")
+
+// CompressedSerializer compresses sessions serialized by Serializer with gzip.
+// Sessions which aren't compressed can still be deserialized.
+type CompressedSerializer struct {
+	Serializer SessionSerializer
+	// Threshold is the size in bytes from which serialized sessions are compressed,
+	// smaller sessions are stored uncompressed. Zero compresses all sessions
+	Threshold int
+}
+
+func (cs CompressedSerializer) Serialize(s *sessions.Session) ([]byte, error) {
+	b, err := cs.Serializer.Serialize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < cs.Threshold {
+		return b, nil
+	}
+	return Compress(b)
+}
+
+func (cs CompressedSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	b, err := Decompress(d)
+	if err != nil {
+		return err
+	}
+	return cs.Serializer.Deserialize(b, s)
+}
+
+// Compress compresses data with gzip, prefixed with a marker so that Decompress
+// can tell compressed from uncompressed data
+func Compress(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, gzipMagic...))
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses data compressed by Compress, data without the
+// compression marker is returned as-is
+func Decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data[len(gzipMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
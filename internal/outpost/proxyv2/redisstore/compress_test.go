@@ -0,0 +1,52 @@
+package redisstore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedSerializer(t *testing.T) {
+	s := sessions.NewSession(nil, "hello")
+	s.Values["key"] = strings.Repeat("value", 1000)
+	cs := CompressedSerializer{Serializer: GobSerializer{}}
+
+	compressed, err := cs.Serialize(s)
+	assert.NoError(t, err)
+	plain, err := GobSerializer{}.Serialize(s)
+	assert.NoError(t, err)
+	assert.Less(t, len(compressed), len(plain))
+
+	for _, b := range [][]byte{compressed, plain} {
+		d := sessions.NewSession(nil, "hello")
+		assert.NoError(t, cs.Deserialize(b, d))
+		assert.Equal(t, s.Values["key"], d.Values["key"])
+	}
+
+	// Serializers read compressed sessions without being wrapped
+	for _, ss := range []SessionSerializer{GobSerializer{}, JSONSerializer{}} {
+		b, err := CompressedSerializer{Serializer: ss}.Serialize(s)
+		assert.NoError(t, err)
+		d := sessions.NewSession(nil, "hello")
+		assert.NoError(t, ss.Deserialize(b, d))
+		assert.Equal(t, s.Values["key"], d.Values["key"])
+	}
+}
+
+func TestCompressedSerializer_Threshold(t *testing.T) {
+	small := sessions.NewSession(nil, "hello")
+	small.Values["key"] = "value"
+	large := sessions.NewSession(nil, "hello")
+	large.Values["key"] = strings.Repeat("value", 1000)
+	cs := CompressedSerializer{Serializer: GobSerializer{}, Threshold: 1024}
+
+	b, err := cs.Serialize(small)
+	assert.NoError(t, err)
+	assert.False(t, bytes.HasPrefix(b, gzipMagic))
+	b, err = cs.Serialize(large)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(b, gzipMagic))
+}
@@ -0,0 +1,122 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/gorilla/sessions"
+)
+
+// JSONSerializerVersion is the version of the session format written by JSONSerializer
+const JSONSerializerVersion = 1
+
+var jsonTypes sync.Map
+
+func init() {
+	RegisterJSONType("")
+	RegisterJSONType(0)
+	RegisterJSONType(int64(0))
+	RegisterJSONType(float64(0))
+	RegisterJSONType(false)
+}
+
+// RegisterJSONType records the type of value, so that session values of that type
+// are deserialized into the same type by the JSONSerializer, similar to gob.Register.
+func RegisterJSONType(value interface{}) {
+	t := indirectType(reflect.TypeOf(value))
+	jsonTypes.Store(t.String(), t)
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+type jsonValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonSession struct {
+	Version int                  `json:"version"`
+	Values  map[string]jsonValue `json:"values"`
+}
+
+// JSONSerializer serializes sessions as versioned JSON. Unlike gob, unknown and
+// missing fields are tolerated, so stored structs can evolve between versions.
+// Values of types registered with RegisterJSONType are deserialized into their
+// original type, pointers are dereferenced like gob does.
+// Sessions serialized by the GobSerializer can still be deserialized.
+type JSONSerializer struct{}
+
+func (js JSONSerializer) Serialize(s *sessions.Session) ([]byte, error) {
+	data := jsonSession{
+		Version: JSONSerializerVersion,
+		Values:  make(map[string]jsonValue, len(s.Values)),
+	}
+	for k, v := range s.Values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("redisstore: session key %v is not a string", k)
+		}
+		rv := reflect.ValueOf(v)
+		for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			rv = rv.Elem()
+		}
+		typeName := ""
+		if rv.IsValid() {
+			typeName = rv.Type().String()
+			v = rv.Interface()
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data.Values[key] = jsonValue{
+			Type:  typeName,
+			Value: raw,
+		}
+	}
+	return json.Marshal(data)
+}
+
+// Deserialize decodes sessions serialized by Serialize or by GobSerializer, which may have
+// been compressed by CompressedSerializer
+func (js JSONSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	d, err := Decompress(d)
+	if err != nil {
+		return err
+	}
+	data := jsonSession{}
+	if err := json.Unmarshal(d, &data); err != nil || data.Version == 0 {
+		// Sessions stored before switching to the JSON serializer are gob-encoded
+		return GobSerializer{}.Deserialize(d, s)
+	}
+	if data.Version > JSONSerializerVersion {
+		return fmt.Errorf("redisstore: unsupported session version %d", data.Version)
+	}
+	if s.Values == nil {
+		s.Values = make(map[interface{}]interface{}, len(data.Values))
+	}
+	for key, value := range data.Values {
+		t, ok := jsonTypes.Load(value.Type)
+		if !ok {
+			var v interface{}
+			if err := json.Unmarshal(value.Value, &v); err != nil {
+				return err
+			}
+			s.Values[key] = v
+			continue
+		}
+		v := reflect.New(t.(reflect.Type))
+		if err := json.Unmarshal(value.Value, v.Interface()); err != nil {
+			return err
+		}
+		s.Values[key] = v.Elem().Interface()
+	}
+	return nil
+}
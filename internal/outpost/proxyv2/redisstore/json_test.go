@@ -0,0 +1,74 @@
+package redisstore
+
+import (
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+type jsonTestStruct struct {
+	Name   string            `json:"name"`
+	Nested map[string]string `json:"nested"`
+}
+
+func init() {
+	RegisterJSONType(jsonTestStruct{})
+}
+
+func TestJSONSerializer(t *testing.T) {
+	s := sessions.NewSession(nil, "hello")
+	v := &jsonTestStruct{
+		Name: "foo",
+		Nested: map[string]string{
+			"bar": "baz",
+		},
+	}
+	s.Values["struct"] = &v
+	s.Values["string"] = "value"
+	s.Values["int"] = 3
+
+	b, err := JSONSerializer{}.Serialize(s)
+	assert.NoError(t, err)
+
+	d := sessions.NewSession(nil, "hello")
+	assert.NoError(t, JSONSerializer{}.Deserialize(b, d))
+	assert.Equal(t, *v, d.Values["struct"])
+	assert.Equal(t, "value", d.Values["string"])
+	assert.Equal(t, 3, d.Values["int"])
+}
+
+func TestJSONSerializer_Drift(t *testing.T) {
+	d := sessions.NewSession(nil, "hello")
+	assert.NoError(t, JSONSerializer{}.Deserialize([]byte(`{
+		"version": 1,
+		"values": {
+			"struct": {
+				"type": "redisstore.jsonTestStruct",
+				"value": {"name": "foo", "removed_field": true}
+			},
+			"unknown": {
+				"type": "redisstore.unknownType",
+				"value": {"foo": "bar"}
+			}
+		}
+	}`), d))
+	assert.Equal(t, jsonTestStruct{Name: "foo"}, d.Values["struct"])
+	assert.Equal(t, map[string]interface{}{"foo": "bar"}, d.Values["unknown"])
+}
+
+func TestJSONSerializer_Gob(t *testing.T) {
+	s := sessions.NewSession(nil, "hello")
+	s.Values["string"] = "value"
+	b, err := GobSerializer{}.Serialize(s)
+	assert.NoError(t, err)
+
+	d := sessions.NewSession(nil, "hello")
+	assert.NoError(t, JSONSerializer{}.Deserialize(b, d))
+	assert.Equal(t, "value", d.Values["string"])
+}
+
+func TestJSONSerializer_Version(t *testing.T) {
+	d := sessions.NewSession(nil, "hello")
+	assert.Error(t, JSONSerializer{}.Deserialize([]byte(`{"version": 99, "values": {}}`), d))
+}
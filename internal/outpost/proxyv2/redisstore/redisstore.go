@@ -8,10 +8,13 @@ import (
 	"encoding/gob"
 	"errors"
 	"io"
+	"math"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/redis/go-redis/v9"
 )
@@ -20,6 +23,8 @@ import (
 type RedisStore struct {
 	// client to connect to redis
 	client redis.UniversalClient
+	// optional client of a read replica, from which sessions are loaded
+	readClient redis.UniversalClient
 	// default options to use when a new session is created
 	options sessions.Options
 	// key prefix with which the session will be stored
@@ -28,11 +33,26 @@ type RedisStore struct {
 	keyGen KeyGenFunc
 	// session serializer
 	serializer SessionSerializer
+	// timeout of each command issued while scanning, zero disables the timeout
+	scanTimeout time.Duration
+	// maximum length of serialized sessions, zero disables the limit
+	maxLength int
+	// optional function returning the keys deleted along with the key of a session
+	linkedKeys func(key string) []string
+	// optional codecs with which signed session IDs in cookies are decoded
+	cookieCodecs []securecookie.Codec
+	// optional function returning the ID under which a session is stored
+	storageID func(id string) string
+	// optional function returning the IDs under which a session was stored before
+	previousStorageIDs func(id string) []string
 }
 
 // KeyGenFunc defines a function used by store to generate a key
 type KeyGenFunc func() (string, error)
 
+// ScanCount is the COUNT hint sent with each SCAN when iterating session keys
+const ScanCount = 100
+
 // NewRedisStore returns a new RedisStore with default configuration
 func NewRedisStore(ctx context.Context, client redis.UniversalClient) (*RedisStore, error) {
 	rs := &RedisStore{
@@ -53,6 +73,14 @@ func (s *RedisStore) Client() redis.UniversalClient {
 	return s.client
 }
 
+// ReadClient sets the client of a read replica, from which sessions are loaded when
+// handling requests. All writes and scans still go to the primary client, and sessions
+// which are not found on the replica, for example because they were saved just now and
+// not replicated yet, are loaded from the primary, as are sessions when the replica fails.
+func (s *RedisStore) ReadClient(client redis.UniversalClient) {
+	s.readClient = client
+}
+
 // Get returns a session for the given name after adding it to the registry.
 func (s *RedisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
 	return sessions.GetRegistry(r).Get(s, name)
@@ -72,6 +100,13 @@ func (s *RedisStore) New(r *http.Request, name string) (*sessions.Session, error
 	session.ID = c.Value
 
 	err = s.load(r.Context(), session)
+	if errors.Is(err, redis.Nil) && len(s.cookieCodecs) > 0 {
+		var id string
+		if securecookie.DecodeMulti(name, c.Value, &id, s.cookieCodecs...) == nil {
+			session.ID = id
+			err = s.load(r.Context(), session)
+		}
+	}
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return session, nil
@@ -118,6 +153,14 @@ func (s *RedisStore) Options(opts sessions.Options) {
 	s.options = opts
 }
 
+// CookieCodecs sets codecs with which the session ID in a cookie is decoded when no
+// session is stored under the cookie's value, for sessions which were migrated from a
+// store which signs the session ID in the cookie, such as the filesystem store. The
+// cookie is replaced with the plain session ID once the session is saved.
+func (s *RedisStore) CookieCodecs(cs ...securecookie.Codec) {
+	s.cookieCodecs = cs
+}
+
 // KeyPrefix sets the key prefix to store session in Redis
 func (s *RedisStore) KeyPrefix(keyPrefix string) {
 	s.keyPrefix = keyPrefix
@@ -133,39 +176,302 @@ func (s *RedisStore) Serializer(ss SessionSerializer) {
 	s.serializer = ss
 }
 
+// MaxLength restricts the maximum length of serialized sessions stored in Redis to l.
+// If l is 0 there is no limit to the size of a session, which is the default.
+func (s *RedisStore) MaxLength(l int) {
+	s.maxLength = l
+}
+
+// ScanTimeout sets the timeout of each command issued by Scan, so that a stalled
+// connection can't block a scan indefinitely
+func (s *RedisStore) ScanTimeout(d time.Duration) {
+	s.scanTimeout = d
+}
+
+// Scan iterates over all session keys with the store's key prefix using SCAN,
+// calling fn with each batch of keys returned by Redis. Unlike KEYS, this does
+// not block the Redis server while walking the keyspace.
+// When the store is backed by a Redis Cluster, every master is scanned
+// one after the other, as SCAN only covers the keyspace of a single node.
+// If the key prefix contains a hash tag, all keys are in the same slot and only
+// the master of that slot is scanned.
+// Keys may be returned more than once, as guaranteed by SCAN.
+func (s *RedisStore) Scan(ctx context.Context, fn func(keys []string) error) error {
+	return s.ScanPrefix(ctx, "", fn)
+}
+
+// ScanPrefix iterates over the session keys like Scan, limited to sessions whose ID
+// starts with idPrefix
+func (s *RedisStore) ScanPrefix(ctx context.Context, idPrefix string, fn func(keys []string) error) error {
+	match := s.keyPrefix + escapeGlob(idPrefix) + "*"
+	cc, ok := s.client.(*redis.ClusterClient)
+	if !ok {
+		return s.scanNode(ctx, s.client, match, fn)
+	}
+	if HashTag(s.keyPrefix) != "" {
+		node, err := cc.MasterForKey(ctx, s.keyPrefix)
+		if err != nil {
+			return err
+		}
+		return s.scanNode(ctx, node, match, fn)
+	}
+	var mu sync.Mutex
+	nodes := []*redis.Client{}
+	err := cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := s.scanNode(ctx, node, match, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanNode runs a full SCAN cursor loop against a single node
+func (s *RedisStore) scanNode(ctx context.Context, client redis.Cmdable, match string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := s.scanPage(ctx, client, match, cursor)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// scanPage runs a single SCAN, with the configured timeout
+func (s *RedisStore) scanPage(ctx context.Context, client redis.Cmdable, match string, cursor uint64) ([]string, uint64, error) {
+	if s.scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.scanTimeout)
+		defer cancel()
+	}
+	return client.Scan(ctx, cursor, match, ScanCount).Result()
+}
+
+// escapeGlob escapes the characters with a special meaning in SCAN MATCH patterns
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// HashTag returns the hash tag of key, which Redis Cluster hashes instead of the whole key
+// to find its slot. It is the part between the first { and the following }, and is empty
+// when the key has no hash tag.
+func HashTag(key string) string {
+	_, rest, ok := strings.Cut(key, "{")
+	if !ok {
+		return ""
+	}
+	tag, _, ok := strings.Cut(rest, "}")
+	if !ok {
+		return ""
+	}
+	return tag
+}
+
+// LinkedKeys sets a function returning the keys which are deleted along with the key of
+// a session, such as keys holding values stored outside of the session. The keys are
+// deleted with separate commands, so they may be in a different Redis Cluster slot,
+// unless they contain the same hash tag as the session key.
+func (s *RedisStore) LinkedKeys(fn func(key string) []string) {
+	s.linkedKeys = fn
+}
+
+// StorageID sets a function returning the ID under which the session with the given ID is
+// stored, for example a hash of it so that keys don't reveal usable session IDs. The
+// session ID is still sent in the cookie, and serializers are passed sessions with their
+// storage ID.
+func (s *RedisStore) StorageID(fn func(id string) string) {
+	s.storageID = fn
+}
+
+// PreviousStorageIDs sets a function returning the IDs under which the session with the
+// given ID may have been stored before, for example hashes of it with keys which were
+// rotated since. Sessions which aren't found under their storage ID are loaded from the
+// first previous ID they are found under, and moved to their storage ID.
+func (s *RedisStore) PreviousStorageIDs(fn func(id string) []string) {
+	s.previousStorageIDs = fn
+}
+
+// key returns the key of the session with the given ID
+func (s *RedisStore) key(id string) string {
+	if s.storageID != nil {
+		id = s.storageID(id)
+	}
+	return s.keyPrefix + id
+}
+
+// previousKeys returns the keys the session with the given ID may have been stored under
+// before, see PreviousStorageIDs
+func (s *RedisStore) previousKeys(id string) []string {
+	if s.previousStorageIDs == nil {
+		return nil
+	}
+	keys := []string{}
+	for _, previous := range s.previousStorageIDs(id) {
+		keys = append(keys, s.keyPrefix+previous)
+	}
+	return keys
+}
+
+// Delete deletes the session with the given ID from Redis, including copies of it stored
+// under a previous storage ID
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	keys := append([]string{s.key(id)}, s.previousKeys(id)...)
+	if s.linkedKeys == nil && len(keys) == 1 {
+		return s.client.Del(ctx, keys[0]).Err()
+	}
+	pipe := s.client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key)
+		if s.linkedKeys == nil {
+			continue
+		}
+		for _, linked := range s.linkedKeys(key) {
+			pipe.Del(ctx, linked)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// LinkedKeysOf returns the keys which are deleted along with the session key, see LinkedKeys
+func (s *RedisStore) LinkedKeysOf(key string) []string {
+	if s.linkedKeys == nil {
+		return nil
+	}
+	return s.linkedKeys(key)
+}
+
 // Close closes the Redis store
 func (s *RedisStore) Close() error {
+	if s.readClient != nil {
+		if err := s.readClient.Close(); err != nil {
+			return err
+		}
+	}
 	return s.client.Close()
 }
 
 // save writes session in Redis
 func (s *RedisStore) save(ctx context.Context, session *sessions.Session) error {
-	b, err := s.serializer.Serialize(session)
+	key := s.key(session.ID)
+	stored := *session
+	stored.ID = strings.TrimPrefix(key, s.keyPrefix)
+	b, err := s.serializer.Serialize(&stored)
 	if err != nil {
 		return err
 	}
+	if s.maxLength > 0 && len(b) > s.maxLength {
+		return errors.New("redisstore: the value is too long")
+	}
 
-	return s.client.Set(ctx, s.keyPrefix+session.ID, b, time.Duration(session.Options.MaxAge)*time.Second).Err()
+	// SET replaces the TTL of an existing key, so every save extends the session to its
+	// current MaxAge
+	return s.client.Set(ctx, key, b, time.Duration(session.Options.MaxAge)*time.Second).Err()
 }
 
 // load reads session from Redis
 func (s *RedisStore) load(ctx context.Context, session *sessions.Session) error {
-	cmd := s.client.Get(ctx, s.keyPrefix+session.ID)
-	if cmd.Err() != nil {
-		return cmd.Err()
+	key := s.key(session.ID)
+	b, err := s.get(ctx, key)
+	if errors.Is(err, redis.Nil) {
+		return s.loadPrevious(ctx, session)
 	}
-
-	b, err := cmd.Bytes()
 	if err != nil {
 		return err
 	}
 
-	return s.serializer.Deserialize(b, session)
+	stored := *session
+	stored.ID = strings.TrimPrefix(key, s.keyPrefix)
+	if err := s.serializer.Deserialize(b, &stored); err != nil {
+		return err
+	}
+	session.Values = stored.Values
+	return nil
+}
+
+// loadPrevious reads session from the first of its previous keys it is found under, and
+// moves it to its current key with the TTL it had left. Returns redis.Nil when the session
+// isn't found under any of its previous keys.
+func (s *RedisStore) loadPrevious(ctx context.Context, session *sessions.Session) error {
+	for _, key := range s.previousKeys(session.ID) {
+		b, err := s.get(ctx, key)
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stored := *session
+		stored.ID = strings.TrimPrefix(key, s.keyPrefix)
+		if err := s.serializer.Deserialize(b, &stored); err != nil {
+			return err
+		}
+		session.Values = stored.Values
+
+		// The session was loaded, failing to move it leaves it under the previous key,
+		// from which it is loaded again
+		ttl, err := s.client.PTTL(ctx, key).Result()
+		if err != nil || ttl == -2 {
+			return nil
+		}
+		moved := *session
+		opts := sessions.Options{}
+		if session.Options != nil {
+			opts = *session.Options
+		}
+		// A TTL of -1 means the key doesn't expire
+		opts.MaxAge = max(int(math.Ceil(ttl.Seconds())), 0)
+		moved.Options = &opts
+		if s.save(ctx, &moved) != nil {
+			return nil
+		}
+		pipe := s.client.Pipeline()
+		pipe.Del(ctx, key)
+		if s.linkedKeys != nil {
+			for _, linked := range s.linkedKeys(key) {
+				pipe.Del(ctx, linked)
+			}
+		}
+		_, _ = pipe.Exec(ctx)
+		return nil
+	}
+	return redis.Nil
+}
+
+// get returns the value of key, from the read replica if one is set. When the key
+// isn't found on the replica or the replica fails, it is read from the primary.
+func (s *RedisStore) get(ctx context.Context, key string) ([]byte, error) {
+	if s.readClient != nil {
+		b, err := s.readClient.Get(ctx, key).Bytes()
+		if err == nil {
+			return b, nil
+		}
+	}
+	return s.client.Get(ctx, key).Bytes()
 }
 
 // delete deletes session in Redis
 func (s *RedisStore) delete(ctx context.Context, session *sessions.Session) error {
-	return s.client.Del(ctx, s.keyPrefix+session.ID).Err()
+	return s.Delete(ctx, session.ID)
 }
 
 // SessionSerializer provides an interface for serialize/deserialize a session
@@ -187,7 +493,13 @@ func (gs GobSerializer) Serialize(s *sessions.Session) ([]byte, error) {
 	return nil, err
 }
 
+// Deserialize decodes sessions serialized by Serialize, which may have been compressed
+// by CompressedSerializer
 func (gs GobSerializer) Deserialize(d []byte, s *sessions.Session) error {
+	d, err := Decompress(d)
+	if err != nil {
+		return err
+	}
 	dec := gob.NewDecoder(bytes.NewBuffer(d))
 	return dec.Decode(&s.Values)
 }
@@ -2,10 +2,12 @@ package redisstore
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 	"github.com/redis/go-redis/v9"
 )
@@ -156,3 +158,369 @@ func TestClose(t *testing.T) {
 		t.Fatal("connection is properly closed")
 	}
 }
+
+type commandRecorder struct {
+	commands []string
+}
+
+func (cr *commandRecorder) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (cr *commandRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		cr.commands = append(cr.commands, cmd.Name())
+		return next(ctx, cmd)
+	}
+}
+
+func (cr *commandRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestScan(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+
+	store, err := NewRedisStore(context.Background(), client)
+	if err != nil {
+		t.Fatal("failed to create redis store", err)
+	}
+	store.KeyPrefix("scan_test_")
+
+	seeded := 5000
+	pipe := client.Pipeline()
+	for i := 0; i < seeded; i++ {
+		pipe.Set(context.Background(), fmt.Sprintf("scan_test_%d", i), "value", 0)
+	}
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		t.Fatal("failed to seed keys", err)
+	}
+
+	recorder := &commandRecorder{}
+	client.AddHook(recorder)
+
+	visited := map[string]struct{}{}
+	err = store.Scan(context.Background(), func(keys []string) error {
+		for _, key := range keys {
+			visited[key] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("failed to scan", err)
+	}
+	if len(visited) != seeded {
+		t.Fatalf("scan visited %d keys, expected %d", len(visited), seeded)
+	}
+	for _, cmd := range recorder.commands {
+		if cmd == "keys" {
+			t.Fatal("scan issued KEYS")
+		}
+	}
+
+	for key := range visited {
+		client.Del(context.Background(), key)
+	}
+}
+
+func TestReadClient(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	store, err := NewRedisStore(context.Background(), client)
+	if err != nil {
+		t.Fatal("failed to create redis store", err)
+	}
+	// A different database stands in for a replica which hasn't replicated the session yet
+	replica := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+		DB:   1,
+	})
+	recorder := &commandRecorder{}
+	replica.AddHook(recorder)
+	store.ReadClient(replica)
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	w := httptest.NewRecorder()
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+	session.Values["key"] = "value"
+	if err := session.Save(req, w); err != nil {
+		t.Fatal("failed to save: ", err)
+	}
+	if len(recorder.commands) != 0 {
+		t.Fatalf("session was written to the replica: %v", recorder.commands)
+	}
+
+	req.AddCookie(w.Result().Cookies()[0])
+	session, err = store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to load session", err)
+	}
+	if session.IsNew || session.Values["key"] != "value" {
+		t.Fatal("session missing on the replica was not loaded from the primary")
+	}
+	if len(recorder.commands) != 1 || recorder.commands[0] != "get" {
+		t.Fatalf("session was not read from the replica: %v", recorder.commands)
+	}
+}
+
+func TestLinkedKeys(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+
+	store, err := NewRedisStore(context.Background(), client)
+	if err != nil {
+		t.Fatal("failed to create redis store", err)
+	}
+	store.LinkedKeys(func(key string) []string {
+		return []string{"linked:" + key}
+	})
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+	session.Values["key"] = "value"
+	if err := session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Fatal("failed to save session: ", err)
+	}
+	linked := "linked:session:" + session.ID
+	if err := client.Set(context.Background(), linked, "value", 0).Err(); err != nil {
+		t.Fatal("failed to set linked key: ", err)
+	}
+
+	if err := store.Delete(context.Background(), session.ID); err != nil {
+		t.Fatal("failed to delete session: ", err)
+	}
+	if n := client.Exists(context.Background(), linked).Val(); n != 0 {
+		t.Fatal("linked key was not deleted")
+	}
+}
+
+func TestStorageID(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+
+	store, err := NewRedisStore(context.Background(), client)
+	if err != nil {
+		t.Fatal("failed to create redis store", err)
+	}
+	store.StorageID(func(id string) string {
+		return "hashed:" + id
+	})
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+	session.Values["key"] = "value"
+	w := httptest.NewRecorder()
+	if err := session.Save(req, w); err != nil {
+		t.Fatal("failed to save session: ", err)
+	}
+	if n := client.Exists(context.Background(), "session:"+session.ID).Val(); n != 0 {
+		t.Fatal("session was stored under its ID")
+	}
+	if n := client.Exists(context.Background(), "session:hashed:"+session.ID).Val(); n != 1 {
+		t.Fatal("session was not stored under its storage ID")
+	}
+
+	// The cookie carries the session ID, which is mapped to the storage ID again
+	req.AddCookie(w.Result().Cookies()[0])
+	loaded, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to load session", err)
+	}
+	if loaded.IsNew || loaded.ID != session.ID || loaded.Values["key"] != "value" {
+		t.Fatal("session was not loaded")
+	}
+
+	if err := store.Delete(context.Background(), session.ID); err != nil {
+		t.Fatal("failed to delete session: ", err)
+	}
+	if n := client.Exists(context.Background(), "session:hashed:"+session.ID).Val(); n != 0 {
+		t.Fatal("session was not deleted")
+	}
+}
+
+func TestPreviousStorageIDs(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+
+	store, err := NewRedisStore(context.Background(), client)
+	if err != nil {
+		t.Fatal("failed to create redis store", err)
+	}
+	store.StorageID(func(id string) string {
+		return "old:" + id
+	})
+
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to create session", err)
+	}
+	session.Values["key"] = "value"
+	w := httptest.NewRecorder()
+	if err := session.Save(req, w); err != nil {
+		t.Fatal("failed to save session: ", err)
+	}
+
+	// Sessions stored under a previous ID are moved to their current ID when loaded
+	store.StorageID(func(id string) string {
+		return "new:" + id
+	})
+	store.PreviousStorageIDs(func(id string) []string {
+		return []string{"other:" + id, "old:" + id}
+	})
+	req.AddCookie(w.Result().Cookies()[0])
+	loaded, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to load session", err)
+	}
+	if loaded.IsNew || loaded.Values["key"] != "value" {
+		t.Fatal("session was not loaded from its previous ID")
+	}
+	if n := client.Exists(context.Background(), "session:old:"+session.ID).Val(); n != 0 {
+		t.Fatal("session was not removed from its previous ID")
+	}
+	if ttl := client.TTL(context.Background(), "session:new:"+session.ID).Val(); ttl <= 0 {
+		t.Fatal("session was not moved with its TTL")
+	}
+
+	if err := store.Delete(context.Background(), session.ID); err != nil {
+		t.Fatal("failed to delete session: ", err)
+	}
+	if n := client.Exists(context.Background(), "session:new:"+session.ID).Val(); n != 0 {
+		t.Fatal("session was not deleted")
+	}
+}
+
+func TestCookieCodecs(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+
+	store, err := NewRedisStore(context.Background(), client)
+	if err != nil {
+		t.Fatal("failed to create redis store", err)
+	}
+	codec := securecookie.New([]byte("secret"), nil)
+	store.CookieCodecs(codec)
+	values, err := GobSerializer{}.Serialize(&sessions.Session{Values: map[interface{}]interface{}{}})
+	if err != nil {
+		t.Fatal("failed to serialize session: ", err)
+	}
+	if err := client.Set(context.Background(), "session:migrated", values, 0).Err(); err != nil {
+		t.Fatal("failed to set session: ", err)
+	}
+	defer client.Del(context.Background(), "session:migrated")
+
+	// Cookies with a signed session ID are decoded with the codecs
+	encoded, err := securecookie.EncodeMulti("hello", "migrated", codec)
+	if err != nil {
+		t.Fatal("failed to encode session ID: ", err)
+	}
+	req, err := http.NewRequest("GET", "http://www.example.com", nil)
+	if err != nil {
+		t.Fatal("failed to create request", err)
+	}
+	req.AddCookie(&http.Cookie{Name: "hello", Value: encoded})
+	session, err := store.New(req, "hello")
+	if err != nil {
+		t.Fatal("failed to load session: ", err)
+	}
+	if session.IsNew || session.ID != "migrated" {
+		t.Fatalf("migrated session was not loaded, got ID %q", session.ID)
+	}
+}
+
+func TestHashTag(t *testing.T) {
+	for key, tag := range map[string]string{
+		"session_foo":           "",
+		"session_{foo}_bar":     "foo",
+		"session_{foo}_{bar}":   "foo",
+		"session_{}_foo":        "",
+		"session_{foo":          "",
+		"claims:session_{foo}_": "foo",
+	} {
+		if got := HashTag(key); got != tag {
+			t.Fatalf("hash tag of %s is %q, expected %q", key, got, tag)
+		}
+	}
+}
+
+func TestScan_HashTag(t *testing.T) {
+	clients := map[string]redis.UniversalClient{
+		"single": redis.NewClient(&redis.Options{
+			Addr: redisAddr,
+		}),
+		// A cluster client whose only node serves all slots
+		"cluster": redis.NewClusterClient(&redis.ClusterOptions{
+			ClusterSlots: func(ctx context.Context) ([]redis.ClusterSlot, error) {
+				return []redis.ClusterSlot{{
+					Start: 0,
+					End:   16383,
+					Nodes: []redis.ClusterNode{{Addr: redisAddr}},
+				}}, nil
+			},
+		}),
+	}
+	for topology, client := range clients {
+		t.Run(topology, func(t *testing.T) {
+			store, err := NewRedisStore(context.Background(), client)
+			if err != nil {
+				t.Fatal("failed to create redis store", err)
+			}
+			store.KeyPrefix("scan_{foo}_")
+
+			seeded := 100
+			for i := 0; i < seeded; i++ {
+				client.Set(context.Background(), fmt.Sprintf("scan_{foo}_%d", i), "value", 0)
+				client.Set(context.Background(), fmt.Sprintf("scan_{bar}_%d", i), "value", 0)
+			}
+
+			visited := map[string]struct{}{}
+			err = store.Scan(context.Background(), func(keys []string) error {
+				for _, key := range keys {
+					visited[key] = struct{}{}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal("failed to scan", err)
+			}
+			if len(visited) != seeded {
+				t.Fatalf("scan visited %d keys, expected %d", len(visited), seeded)
+			}
+			for i := 0; i < seeded; i++ {
+				if err := store.Delete(context.Background(), fmt.Sprint(i)); err != nil {
+					t.Fatal("failed to delete", err)
+				}
+				client.Del(context.Background(), fmt.Sprintf("scan_{bar}_%d", i))
+			}
+		})
+	}
+}
@@ -45,15 +45,26 @@ func (ps *ProxyServer) Refresh() error {
 		}
 		existing, ok := ps.apps[externalHost.Host]
 		a, err := application.NewApplication(provider, hc, ps, existing)
-		if ok {
-			existing.Stop()
-		}
 		if err != nil {
 			ps.log.WithError(err).Warning("failed to setup application")
 			continue
 		}
+		if ok {
+			existing.Stop()
+		}
 		apps[externalHost.Host] = a
 	}
+	// Applications which were removed or failed to be set up again didn't hand over their
+	// session store, so their goroutines and connections are stopped
+	for host, app := range ps.apps {
+		if _, ok := apps[host]; ok {
+			continue
+		}
+		app.Stop()
+		if err := app.Close(); err != nil {
+			ps.log.WithError(err).Warning("failed to close session store")
+		}
+	}
 	ps.apps = apps
 	ps.log.Debug("Swapped maps")
 	return nil
@@ -11,11 +11,14 @@ type WSProviderSubType string
 
 const (
 	WSProviderSubTypeLogout WSProviderSubType = "logout"
+	// Logs out the sessions of the members of a group
+	WSProviderSubTypeLogoutGroup WSProviderSubType = "logout_group"
 )
 
 type WSProviderMsg struct {
 	SubType   WSProviderSubType `mapstructure:"sub_type"`
 	SessionID string            `mapstructure:"session_id"`
+	Group     string            `mapstructure:"group"`
 }
 
 func ParseWSProvider(args map[string]interface{}) (*WSProviderMsg, error) {
@@ -37,9 +40,19 @@ func (ps *ProxyServer) handleWSMessage(ctx context.Context, args map[string]inte
 	case WSProviderSubTypeLogout:
 		for _, p := range ps.apps {
 			ps.log.WithField("provider", p.Host).Debug("Logging out")
-			err := p.Logout(ctx, func(c application.Claims) bool {
-				return c.Sid == msg.SessionID
-			})
+			_, err := p.LogoutSID(ctx, msg.SessionID)
+			if err != nil {
+				ps.log.WithField("provider", p.Host).WithError(err).Warning("failed to logout")
+			}
+		}
+	case WSProviderSubTypeLogoutGroup:
+		if msg.Group == "" {
+			ps.log.Warning("group logout without group")
+			return
+		}
+		for _, p := range ps.apps {
+			ps.log.WithField("provider", p.Host).WithField("group", msg.Group).Debug("Logging out group")
+			_, err := p.LogoutCount(ctx, application.InGroup(msg.Group))
 			if err != nil {
 				ps.log.WithField("provider", p.Host).WithError(err).Warning("failed to logout")
 			}
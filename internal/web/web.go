@@ -144,6 +144,12 @@ func (ws *WebServer) upstreamHttpClient() *http.Client {
 }
 
 func (ws *WebServer) Shutdown() {
+	if ws.ProxyServer != nil {
+		ws.log.Info("shutting down embedded outpost")
+		if err := ws.ProxyServer.Stop(); err != nil {
+			ws.log.WithError(err).Warning("failed to stop embedded outpost")
+		}
+	}
 	ws.log.Info("shutting down gunicorn")
 	ws.g.Kill()
 	ws.stop <- struct{}{}